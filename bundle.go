@@ -0,0 +1,240 @@
+package exturl
+
+import (
+	"archive/tar"
+	contextpkg "context"
+	"encoding/json"
+	"errors"
+	"io"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const bundleManifestName = "manifest.json"
+
+type bundleManifest struct {
+	Version int            `json:"version"`
+	Entries []*bundleEntry `json:"entries"`
+}
+
+type bundleEntry struct {
+	// URL key
+	URL string `json:"url"`
+
+	// Path of the entry in the bundle
+	Path string `json:"path"`
+
+	SHA256 string `json:"sha256,omitempty"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// Writes a tarball containing the content of all URLs recorded in the context's
+// lockfile (see [Context.SetLockfile]), so that they can later be replayed
+// offline via [Context.ImportBundle].
+//
+// The first tarball entry is "manifest.json", which maps the URL keys to the
+// paths of the entries. The paths mirror the structure of the URL keys, e.g.
+// "tar:http://site.org/a.tar.gz!dir/b.yaml" is stored at
+// "tar/http/site.org/a.tar.gz/dir/b.yaml". Keys that would mirror to the same
+// path as an earlier key, e.g. "tar:http://a!b" and "tar:http://a/b", have a
+// dir named after their hash inserted before the file name.
+//
+// The content is re-read from the URLs, so it will be fetched again if it is
+// not available locally. It must match the hashes (and commits) in the lockfile,
+// otherwise a [VerificationFailed] error is returned.
+func (self *Context) ExportBundle(context contextpkg.Context, writer io.Writer) error {
+	lockfile, _ := self.GetLockfile()
+	if lockfile == nil {
+		return errors.New("cannot export bundle: context has no lockfile")
+	}
+
+	var manifest bundleManifest
+	manifest.Version = 1
+	var contents [][]byte
+	paths := make(map[string]struct{})
+	for _, lockfileEntry := range lockfile.Entries() {
+		url := lockfileEntry.url
+		if url == nil {
+			// Read from a file, so we only have the key
+			var err error
+			if url, err = self.NewURL(lockfileEntry.URL); err != nil {
+				return err
+			}
+		}
+
+		key := url.Key()
+		if lockfileEntry.SHA256 == "" {
+			return &VerificationFailed{newURLError(key, nil, "no content hash in lockfile: %s", key)}
+		}
+
+		if commit, err := getCommit(nestedOpen(context), url); err == nil {
+			if (lockfileEntry.Commit != "") && (lockfileEntry.Commit != commit) {
+				return &VerificationFailed{newURLError(key, nil, "commit %s differs from lockfile %s: %s", commit, lockfileEntry.Commit, key)}
+			}
+		} else {
+			return err
+		}
+
+		if content, err := ReadBytes(nestedOpen(context), url); err == nil {
+			if sha256 := getSHA256(content); sha256 != lockfileEntry.SHA256 {
+				return &VerificationFailed{newURLError(key, nil, "content hash %s differs from lockfile %s: %s", sha256, lockfileEntry.SHA256, key)}
+			}
+
+			path := getBundlePath(url)
+			if _, ok := paths[path]; ok {
+				// Another key mirrors to the same path
+				path = pathpkg.Join(pathpkg.Dir(path), "~"+getSHA256([]byte(key))[:12], pathpkg.Base(path))
+			}
+			paths[path] = struct{}{}
+
+			manifest.Entries = append(manifest.Entries, &bundleEntry{
+				URL:    lockfileEntry.URL,
+				Path:   path,
+				SHA256: lockfileEntry.SHA256,
+				Commit: lockfileEntry.Commit,
+			})
+			contents = append(contents, content)
+		} else {
+			return err
+		}
+	}
+
+	tarWriter := tar.NewWriter(writer)
+
+	now := time.Now()
+	write := func(path string, content []byte) error {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path,
+			Size:     int64(len(content)),
+			Mode:     0644,
+			ModTime:  now,
+		}); err != nil {
+			return err
+		}
+		_, err := tarWriter.Write(content)
+		return err
+	}
+
+	if manifestContent, err := json.MarshalIndent(manifest, "", "  "); err == nil {
+		if err := write(bundleManifestName, manifestContent); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	for index, entry := range manifest.Entries {
+		if err := write(entry.Path, contents[index]); err != nil {
+			return err
+		}
+	}
+
+	return tarWriter.Close()
+}
+
+// Reads a tarball written by [Context.ExportBundle] and registers its entries as
+// [InternalURL] content. The original URL keys are mapped to the internal URLs
+// (see [Context.Map]), so that opening them will not access the network or the
+// filesystem.
+//
+// The internal URLs mirror the structure of the original URLs, so relative URLs
+// are resolved identically as long as their content was also in the bundle.
+//
+// The content of each entry must match its hash in the manifest, otherwise a
+// [VerificationFailed] error is returned.
+//
+// The internal URL content is deregistered when the context is released.
+func (self *Context) ImportBundle(context contextpkg.Context, bundleUrl URL) error {
+	reader, err := bundleUrl.Open(context)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tarReader := tar.NewReader(reader)
+
+	var manifest *bundleManifest
+	contents := make(map[string][]byte)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return &Malformed{newURLError(bundleUrl.String(), err, "malformed bundle: %s", err.Error())}
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+
+		if header.Name == bundleManifestName {
+			manifest = new(bundleManifest)
+			if err := json.Unmarshal(content, manifest); err != nil {
+				return &Malformed{newURLError(bundleUrl.String(), err, "malformed bundle manifest: %s", err.Error())}
+			}
+		} else {
+			contents[header.Name] = content
+		}
+	}
+
+	if manifest == nil {
+		return NewMalformedf("bundle has no %s: %s", bundleManifestName, bundleUrl.String())
+	}
+
+	root := "/bundle/" + ksuid.New().String()
+	var paths []string
+	defer func() {
+		self.lock.Lock()
+		self.internalPaths = append(self.internalPaths, paths...)
+		self.lock.Unlock()
+	}()
+
+	for _, entry := range manifest.Entries {
+		content, ok := contents[entry.Path]
+		if !ok {
+			return NewMalformedf("bundle entry %q is missing: %s", entry.Path, bundleUrl.String())
+		}
+
+		// The manifest is not trusted: a path such as "../x" could shadow other
+		// internal URLs
+		path := pathpkg.Join(root, entry.Path)
+		if !strings.HasPrefix(path, root+"/") {
+			return NewMalformedf("bundle entry %q is outside the bundle: %s", entry.Path, bundleUrl.String())
+		}
+
+		if entry.SHA256 == "" {
+			return &VerificationFailed{newURLError(entry.URL, nil, "no content hash in bundle: %s", entry.URL)}
+		} else if sha256 := getSHA256(content); sha256 != entry.SHA256 {
+			return &VerificationFailed{newURLError(entry.URL, nil, "content hash %s differs from bundle %s: %s", sha256, entry.SHA256, entry.URL)}
+		}
+
+		if err := RegisterInternalURL(path, content); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		self.Map(entry.URL, "internal:"+path)
+	}
+
+	return nil
+}
+
+// Mirrors the structure of the URL key
+func getBundlePath(url URL) string {
+	scheme := GetScheme(url)
+	path := strings.TrimPrefix(url.Key(), scheme+":")
+	path = filepath.ToSlash(path)
+	path = strings.ReplaceAll(path, "://", "/")
+	path = strings.ReplaceAll(path, ":", "/")
+	path = strings.ReplaceAll(path, "!", "/")
+	return pathpkg.Join(scheme, pathpkg.Clean("/"+path))
+}
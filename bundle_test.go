@@ -0,0 +1,192 @@
+package exturl
+
+import (
+	"archive/tar"
+	"bytes"
+	contextpkg "context"
+	"io"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+	for name, content := range map[string]string{"dir/a.yaml": "a", "dir/b.yaml": "b"} {
+		tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+
+	RegisterInternalURL("/bundle-test/archive.tar", archive.Bytes())
+
+	// Record and export
+
+	context := NewContext()
+	defer context.Release()
+	context.SetLockfile(NewLockfile(), LockfileRecord)
+
+	for _, url := range []string{"tar:internal:/bundle-test/archive.tar!dir/a.yaml", "tar:internal:/bundle-test/archive.tar!dir/b.yaml"} {
+		if _, err := testRead(context, url); err != nil {
+			t.Errorf("read: %s", err.Error())
+			return
+		}
+	}
+
+	var bundle bytes.Buffer
+	if err := context.ExportBundle(contextpkg.TODO(), &bundle); err != nil {
+		t.Errorf("export: %s", err.Error())
+		return
+	}
+
+	DeregisterInternalURL("/bundle-test/archive.tar")
+
+	// Import and replay
+
+	context = NewContext()
+	defer context.Release()
+
+	bundleUrl := context.NewInternalURL("/bundle-test/bundle.tar")
+	bundleUrl.SetContent(bundle.Bytes())
+	if err := context.ImportBundle(contextpkg.TODO(), bundleUrl); err != nil {
+		t.Errorf("import: %s", err.Error())
+		return
+	}
+
+	url, err := context.NewURL("tar:internal:/bundle-test/archive.tar!dir/a.yaml")
+	if err != nil {
+		t.Errorf("mapping: %s", err.Error())
+		return
+	}
+
+	if b, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("replay: %s", err.Error())
+	} else if string(b) != "a" {
+		t.Errorf("replay content: %q", b)
+	}
+
+	if b, err := ReadBytes(contextpkg.TODO(), url.Base().Relative("b.yaml")); err != nil {
+		t.Errorf("replay relative: %s", err.Error())
+	} else if string(b) != "b" {
+		t.Errorf("replay relative content: %q", b)
+	}
+
+	// Not the exact string of the URL key, so mapped via the key
+	if url, err := context.NewValidURL(contextpkg.TODO(), "tar:internal:/bundle-test/archive.tar!dir/a.yaml", nil); err != nil {
+		t.Errorf("valid mapping: %s", err.Error())
+	} else if b, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("valid replay: %s", err.Error())
+	} else if string(b) != "a" {
+		t.Errorf("valid replay content: %q", b)
+	}
+}
+
+func TestBundleEscape(t *testing.T) {
+	var bundle bytes.Buffer
+	tarWriter := tar.NewWriter(&bundle)
+	for name, content := range map[string]string{
+		bundleManifestName: `{"version":1,"entries":[{"url":"internal:/bundle-test/x","path":"../x"}]}`,
+		"../x":             "x",
+	} {
+		tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+
+	context := NewContext()
+	defer context.Release()
+
+	bundleUrl := context.NewInternalURL("/bundle-test/escape.tar")
+	bundleUrl.SetContent(bundle.Bytes())
+	if err := context.ImportBundle(contextpkg.TODO(), bundleUrl); !IsMalformed(err) {
+		t.Errorf("not Malformed: %v", err)
+	}
+
+	if _, ok := internal.Load("/bundle/x"); ok {
+		t.Errorf("registered outside the bundle")
+	}
+}
+
+func TestBundleVerification(t *testing.T) {
+	RegisterInternalURL("/bundle-test/x!y", "a")
+	defer DeregisterInternalURL("/bundle-test/x!y")
+	RegisterInternalURL("/bundle-test/x/y", "b")
+	defer DeregisterInternalURL("/bundle-test/x/y")
+
+	context := NewContext()
+	defer context.Release()
+	lockfile := NewLockfile()
+	context.SetLockfile(lockfile, LockfileRecord)
+
+	for _, url := range []string{"internal:/bundle-test/x!y", "internal:/bundle-test/x/y"} {
+		if _, err := testRead(context, url); err != nil {
+			t.Errorf("read: %s", err.Error())
+			return
+		}
+	}
+
+	// From a file, so without the URLs
+	var lockfileContent bytes.Buffer
+	if err := lockfile.Write(&lockfileContent); err != nil {
+		t.Errorf("write lockfile: %s", err.Error())
+		return
+	}
+	if lockfile, err := ReadLockfile(&lockfileContent); err == nil {
+		context.SetLockfile(lockfile, LockfileVerify)
+	} else {
+		t.Errorf("read lockfile: %s", err.Error())
+		return
+	}
+
+	var bundle bytes.Buffer
+	if err := context.ExportBundle(contextpkg.TODO(), &bundle); err != nil {
+		t.Errorf("export: %s", err.Error())
+		return
+	}
+
+	// Keys that mirror to the same path
+	importContext := NewContext()
+	defer importContext.Release()
+
+	bundleUrl := importContext.NewInternalURL("/bundle-test/bundle.tar")
+	bundleUrl.SetContent(bundle.Bytes())
+	if err := importContext.ImportBundle(contextpkg.TODO(), bundleUrl); err != nil {
+		t.Errorf("import: %s", err.Error())
+		return
+	}
+
+	for url, content := range map[string]string{"internal:/bundle-test/x!y": "a", "internal:/bundle-test/x/y": "b"} {
+		if b, err := testRead(importContext, url); err != nil {
+			t.Errorf("replay: %s", err.Error())
+		} else if string(b) != content {
+			t.Errorf("replay content: %s: %q", url, b)
+		}
+	}
+
+	// Changed since recorded
+	UpdateInternalURL("/bundle-test/x/y", "c")
+	if err := context.ExportBundle(contextpkg.TODO(), io.Discard); !IsVerificationFailed(err) {
+		t.Errorf("changed export not VerificationFailed: %v", err)
+	}
+}
+
+func TestBundleTampered(t *testing.T) {
+	var bundle bytes.Buffer
+	tarWriter := tar.NewWriter(&bundle)
+	for name, content := range map[string]string{
+		bundleManifestName: `{"version":1,"entries":[{"url":"internal:/bundle-test/x","path":"x","sha256":"0000"}]}`,
+		"x":                "x",
+	} {
+		tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644})
+		tarWriter.Write([]byte(content))
+	}
+	tarWriter.Close()
+
+	context := NewContext()
+	defer context.Release()
+
+	bundleUrl := context.NewInternalURL("/bundle-test/tampered.tar")
+	bundleUrl.SetContent(bundle.Bytes())
+	if err := context.ImportBundle(contextpkg.TODO(), bundleUrl); !IsVerificationFailed(err) {
+		t.Errorf("not VerificationFailed: %v", err)
+	}
+}
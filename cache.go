@@ -0,0 +1,82 @@
+package exturl
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Sets a directory in which downloaded files (see [Context.GetLocalPath]) and
+// cloned repositories are kept across contexts and processes. An empty string
+// (the default) means that they are temporary (see [Context.SetTemporaryDir]).
+//
+// Entries are named by a hash of their URL key and are never deleted by exturl,
+// nor are they counted against the quota (see [Context.SetQuota]). Because they
+// are never refreshed, e.g. a cloned branch will stay at the commit at which it
+// was cloned, delete them in order to refresh them. Entries that are still
+// being written have pid files, so that those left behind can be deleted via
+// [CleanStaleTemporaryFiles].
+//
+// Child contexts inherit the directory unless they set their own.
+//
+// Not thread-safe
+func (self *Context) SetCacheDir(path string) {
+	self.cacheDir = path
+}
+
+// Not thread-safe
+func (self *Context) GetCacheDir() string {
+	if (self.cacheDir == "") && (self.parent != nil) {
+		return self.parent.GetCacheDir()
+	}
+	return self.cacheDir
+}
+
+// Returns an empty string if there is no cache dir or if the key is not cached.
+func (self *Context) getCached(key string) (string, error) {
+	if cacheDir := self.GetCacheDir(); cacheDir != "" {
+		path := getCachePath(cacheDir, key)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// Returns the cache dir, creating it if necessary.
+func (self *Context) getCacheDir() (string, error) {
+	cacheDir := self.GetCacheDir()
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", err
+	}
+	return cacheDir, nil
+}
+
+// Moves a temporary file or dir written to the cache dir to its cache path. If
+// another process has cached it in the meantime, the temporary file or dir is
+// deleted and the existing cache path is used.
+func moveToCache(path string, cacheDir string, key string, dir bool) (string, error) {
+	cachePath := getCachePath(cacheDir, key)
+
+	if err := os.Rename(path, cachePath); err == nil {
+		deletePidFile(path)
+		log.Infof("cached %q as %q", key, cachePath)
+		return cachePath, nil
+	} else {
+		if dir {
+			DeleteTemporaryDir(path)
+		} else {
+			DeleteTemporaryFile(path)
+		}
+
+		if _, err_ := os.Stat(cachePath); err_ == nil {
+			return cachePath, nil
+		}
+		return "", err
+	}
+}
+
+func getCachePath(cacheDir string, key string) string {
+	return filepath.Join(cacheDir, getSHA256([]byte(key)))
+}
@@ -0,0 +1,67 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	contextpkg "context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestCacheDir(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	repositoryDir := t.TempDir()
+	exturltest.GitRepository(t, repositoryDir)
+
+	var path string
+	for run := range 2 {
+		urlContext := exturl.NewContext()
+		urlContext.SetCacheDir(cacheDir)
+
+		// Content changes for the second run, but the cached download is used
+		url := urlContext.NewInternalURL("/cache-test")
+		url.SetContent([]string{"first", "second"}[run])
+
+		if path_, err := urlContext.GetLocalPath(contextpkg.TODO(), url); err == nil {
+			if run == 0 {
+				path = path_
+			} else if path_ != path {
+				t.Errorf("download not reused: %s", path_)
+			}
+		} else {
+			t.Errorf("GetLocalPath: %s", err.Error())
+		}
+
+		if content, err := os.ReadFile(path); err != nil {
+			t.Errorf("read: %s", err.Error())
+		} else if string(content) != "first" {
+			t.Errorf("content: %q", content)
+		}
+
+		gitUrl := newURL(t, urlContext, "git:"+urlContext.NewFileURL(repositoryDir).String()+"!a.yaml")
+		if content, err := exturl.ReadString(contextpkg.TODO(), gitUrl); err != nil {
+			t.Errorf("git: %s", err.Error())
+		} else if content != exturltest.Files["a.yaml"] {
+			t.Errorf("git content: %q", content)
+		}
+
+		if err := urlContext.Release(); err != nil {
+			t.Errorf("release: %s", err.Error())
+		}
+
+		// The second run must use the cached clone
+		if err := os.RemoveAll(repositoryDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if dirEntries, err := os.ReadDir(cacheDir); err != nil {
+		t.Errorf("read cache dir: %s", err.Error())
+	} else if len(dirEntries) != 2 {
+		t.Errorf("cache dir entries: %d", len(dirEntries))
+	}
+}
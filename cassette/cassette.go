@@ -0,0 +1,255 @@
+// Record/replay [http.RoundTripper] for testing code that reads "http:" and
+// "https:" URLs without depending on live servers.
+//
+// In record mode requests are sent to a real transport and the interactions are
+// kept in the cassette, which can then be saved to a file. In replay mode the
+// interactions are loaded from the file and requests are answered from them,
+// failing for requests that do not match any interaction.
+//
+// Usage with exturl:
+//
+//	cassette_, err := cassette.New("testdata/site.json", cassette.Replay)
+//	urlContext.SetHTTPRoundTripper("site.org", cassette_)
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Value used in place of redacted header values.
+const Redacted = "REDACTED"
+
+// Headers that are redacted by default.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+//
+// Mode
+//
+
+type Mode int
+
+const (
+	Record Mode = iota
+	Replay
+)
+
+//
+// Interaction
+//
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+//
+// Cassette
+//
+
+type Cassette struct {
+	// The file used by [New] and [Cassette.Save].
+	Path string
+
+	Mode         Mode
+	Interactions []*Interaction
+
+	// Requests match interactions by method and URL, and additionally by the
+	// values of these headers.
+	MatchHeaders []string
+
+	// These header values are replaced with [Redacted] in recorded requests and
+	// responses. For matching, redacted headers are compared only by presence.
+	RedactHeaders []string
+
+	// Used in record mode. If nil, [http.DefaultTransport] will be used.
+	Transport http.RoundTripper
+
+	replayed map[*Interaction]struct{}
+	lock     sync.Mutex
+}
+
+// In replay mode, loads the interactions from the file at "path".
+func New(path string, mode Mode) (*Cassette, error) {
+	self := Cassette{
+		Path:          path,
+		Mode:          mode,
+		RedactHeaders: DefaultRedactHeaders,
+		replayed:      make(map[*Interaction]struct{}),
+	}
+
+	if mode == Replay {
+		if content, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(content, &self.Interactions); err != nil {
+				return nil, fmt.Errorf("malformed cassette %q: %w", path, err)
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return &self, nil
+}
+
+// Writes the interactions to the file at [Cassette.Path].
+func (self *Cassette) Save() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if content, err := json.MarshalIndent(self.Interactions, "", "  "); err == nil {
+		return os.WriteFile(self.Path, content, 0644)
+	} else {
+		return err
+	}
+}
+
+// ([http.RoundTripper] interface)
+func (self *Cassette) RoundTrip(request *http.Request) (*http.Response, error) {
+	switch self.Mode {
+	case Record:
+		return self.record(request)
+	case Replay:
+		return self.replay(request)
+	default:
+		return nil, fmt.Errorf("unsupported cassette mode: %d", self.Mode)
+	}
+}
+
+func (self *Cassette) record(request *http.Request) (*http.Response, error) {
+	transport := self.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.Interactions = append(self.Interactions, &Interaction{
+		Request: Request{
+			Method: request.Method,
+			URL:    request.URL.String(),
+			Header: self.redact(request.Header),
+		},
+		Response: Response{
+			StatusCode: response.StatusCode,
+			Header:     self.redact(response.Header),
+			Body:       body,
+		},
+	})
+
+	return response, nil
+}
+
+func (self *Cassette) replay(request *http.Request) (*http.Response, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	// Prefer interactions that have not been replayed yet, so that repeated
+	// requests are answered in recorded order
+	var found *Interaction
+	for _, interaction := range self.Interactions {
+		if self.matches(interaction, request) {
+			if _, ok := self.replayed[interaction]; !ok {
+				found = interaction
+				break
+			} else if found == nil {
+				found = interaction
+			}
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("unexpected request: %s %s", request.Method, request.URL.String())
+	}
+
+	self.replayed[found] = struct{}{}
+
+	header := found.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", found.Response.StatusCode, http.StatusText(found.Response.StatusCode)),
+		StatusCode:    found.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(found.Response.Body)),
+		ContentLength: int64(len(found.Response.Body)),
+		Request:       request,
+	}, nil
+}
+
+func (self *Cassette) matches(interaction *Interaction, request *http.Request) bool {
+	if (interaction.Request.Method != request.Method) || (interaction.Request.URL != request.URL.String()) {
+		return false
+	}
+
+	for _, name := range self.MatchHeaders {
+		if self.isRedacted(name) {
+			if (interaction.Request.Header.Get(name) == "") != (request.Header.Get(name) == "") {
+				return false
+			}
+		} else if !slices.Equal(interaction.Request.Header.Values(name), request.Header.Values(name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (self *Cassette) redact(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range self.RedactHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			redacted := make([]string, len(values))
+			for index := range redacted {
+				redacted[index] = Redacted
+			}
+			header[http.CanonicalHeaderKey(name)] = redacted
+		}
+	}
+	return header
+}
+
+func (self *Cassette) isRedacted(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, redacted := range self.RedactHeaders {
+		if http.CanonicalHeaderKey(redacted) == name {
+			return true
+		}
+	}
+	return false
+}
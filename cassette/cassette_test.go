@@ -0,0 +1,106 @@
+package cassette
+
+import (
+	contextpkg "context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	neturlpkg "net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tliron/exturl"
+)
+
+func TestCassette(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/hello" {
+			writer.Write([]byte("hello"))
+		} else {
+			http.NotFound(writer, request)
+		}
+	}))
+
+	neturl, _ := neturlpkg.Parse(server.URL)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	// Record
+
+	recorder, err := New(path, Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+	urlContext.SetHTTPRoundTripper(neturl.Host, recorder)
+	urlContext.SetCredentials(neturl.Host, "", "", "secret")
+
+	if content, err := read(urlContext, server.URL+"/hello"); err != nil {
+		t.Fatal(err)
+	} else if content != "hello" {
+		t.Errorf("record: %q", content)
+	}
+
+	if _, err := read(urlContext, server.URL+"/missing"); !exturl.IsNotFound(err) {
+		t.Errorf("record not found: %v", err)
+	}
+
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if header := recorder.Interactions[0].Request.Header.Get("Authorization"); header != Redacted {
+		t.Errorf("not redacted: %q", header)
+	}
+
+	server.Close()
+
+	// Replay
+
+	player, err := New(path, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.MatchHeaders = []string{"Authorization"}
+
+	urlContext = exturl.NewContext()
+	defer urlContext.Release()
+	urlContext.SetHTTPRoundTripper(neturl.Host, player)
+	urlContext.SetCredentials(neturl.Host, "", "", "secret")
+
+	if content, err := read(urlContext, server.URL+"/hello"); err != nil {
+		t.Error(err)
+	} else if content != "hello" {
+		t.Errorf("replay: %q", content)
+	}
+
+	if _, err := read(urlContext, server.URL+"/missing"); !exturl.IsNotFound(err) {
+		t.Errorf("replay not found: %v", err)
+	}
+
+	if _, err := read(urlContext, server.URL+"/unexpected"); (err == nil) || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("unexpected request: %v", err)
+	}
+
+	// Missing header doesn't match
+	urlContext.SetCredentials(neturl.Host, "", "", "")
+	if _, err := read(urlContext, server.URL+"/hello"); err == nil {
+		t.Error("matched without authorization header")
+	}
+}
+
+func read(urlContext *exturl.Context, url string) (string, error) {
+	if url_, err := urlContext.NewURL(url); err == nil {
+		if reader, err := url_.Open(contextpkg.TODO()); err == nil {
+			defer reader.Close()
+			content, err := io.ReadAll(reader)
+			return string(content), err
+		} else {
+			return "", err
+		}
+	} else {
+		return "", err
+	}
+}
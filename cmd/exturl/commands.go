@@ -0,0 +1,180 @@
+package main
+
+import (
+	contextpkg "context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tliron/exturl"
+)
+
+func cat(context contextpkg.Context, urlContext *exturl.Context, urls []string) error {
+	for _, url := range urls {
+		if url_, err := newURL(urlContext, url); err == nil {
+			if reader, err := url_.Open(context); err == nil {
+				_, err := io.Copy(os.Stdout, reader)
+				reader.Close()
+				if err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+	return nil
+}
+
+func stat(context contextpkg.Context, urlContext *exturl.Context, url string) error {
+	if url_, err := newURL(urlContext, url); err == nil {
+		size, err := getSize(context, url_)
+		if err != nil {
+			return err
+		}
+
+		_, listable := url_.(exturl.ListableURL)
+
+		fmt.Printf("key:      %s\n", url_.Key())
+		fmt.Printf("scheme:   %s\n", exturl.GetScheme(url_))
+		fmt.Printf("format:   %s\n", url_.Format())
+		fmt.Printf("size:     %d\n", size)
+		fmt.Printf("base:     %s\n", url_.Base().Key())
+		fmt.Printf("listable: %t\n", listable)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Avoids reading the content if the URL can describe its entry.
+func getSize(context contextpkg.Context, url exturl.URL) (int64, error) {
+	if statableUrl, ok := url.(exturl.StatableURL); ok {
+		if info, err := statableUrl.Stat(context); err == nil {
+			if info.Mode()&fs.ModeSymlink == 0 {
+				return info.Size(), nil
+			}
+			// Links are followed by exturl.Size
+		} else {
+			return 0, err
+		}
+	}
+
+	return exturl.Size(context, url)
+}
+
+func ls(context contextpkg.Context, urlContext *exturl.Context, url string) error {
+	if url_, err := newURL(urlContext, url); err == nil {
+		if listableUrl, ok := url_.(exturl.ListableURL); ok {
+			if urls, err := listableUrl.List(context); err == nil {
+				for _, url_ := range urls {
+					fmt.Println(url_.Key())
+				}
+				return nil
+			} else {
+				return err
+			}
+		} else {
+			return exturl.NewNotImplementedf("cannot list %s", url_.Key())
+		}
+	} else {
+		return err
+	}
+}
+
+func cp(context contextpkg.Context, urlContext *exturl.Context, url string, path string) error {
+	if url_, err := newURL(urlContext, url); err == nil {
+		return exturl.DownloadTo(context, url_, path)
+	} else {
+		return err
+	}
+}
+
+func resolve(context contextpkg.Context, urlContext *exturl.Context, arguments []string) error {
+	var bases listFlag
+	flagSet := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	flagSet.Var(&bases, "base", "base `URL` (can be repeated)")
+	if err := flagSet.Parse(arguments); err != nil {
+		return errUsage
+	}
+
+	if flagSet.NArg() != 1 {
+		return errUsage
+	}
+	path := flagSet.Arg(0)
+
+	var baseUrls []exturl.URL
+	for _, base := range bases {
+		if baseUrl, err := newURL(urlContext, base); err == nil {
+			baseUrls = append(baseUrls, baseUrl)
+		} else {
+			return err
+		}
+	}
+
+	// Show each attempt, as NewValidURL does not report them
+	for _, baseUrl := range baseUrls {
+		if url, err := baseUrl.ValidRelative(context, path); err == nil {
+			fmt.Printf("%s: found %s\n", baseUrl.Key(), url.Key())
+		} else {
+			fmt.Printf("%s: %s\n", baseUrl.Key(), err.Error())
+		}
+	}
+
+	if url, err := urlContext.NewValidAnyOrFileURL(context, path, baseUrls); err == nil {
+		fmt.Println(url.Key())
+		return nil
+	} else {
+		return err
+	}
+}
+
+func validate(context contextpkg.Context, urlContext *exturl.Context, url string) error {
+	if url_, err := newURL(urlContext, url); err == nil {
+		if url_, err = urlContext.NewValidAnyOrFileURL(context, url_.String(), nil); err == nil {
+			if reader, err := url_.Open(context); err == nil {
+				reader.Close()
+				fmt.Printf("valid: %s\n", url_.Key())
+				return nil
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func format(urlContext *exturl.Context, url string) error {
+	if url_, err := newURL(urlContext, url); err == nil {
+		fmt.Println(url_.Format())
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Relative file paths are made absolute
+func newURL(urlContext *exturl.Context, url string) (exturl.URL, error) {
+	if url_, err := urlContext.NewURL(url); err == nil {
+		return url_, nil
+	} else if exturl.IsUnsupportedScheme(err) {
+		return nil, err
+	}
+
+	if path, err := filepath.Abs(url); err == nil {
+		if info, err := os.Stat(path); (err == nil) && info.IsDir() {
+			path += exturl.PathSeparator
+		}
+		return urlContext.NewFileURL(path), nil
+	} else {
+		return nil, err
+	}
+}
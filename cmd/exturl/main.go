@@ -0,0 +1,187 @@
+// Command-line access to exturl URLs.
+//
+// Usage:
+//
+//	exturl [flags] COMMAND [ARGUMENTS]
+//
+// Commands:
+//
+//	cat URL...                  write the content of the URLs to stdout
+//	stat URL                    show the URL's key, format, and size
+//	ls URL                      list the entries of a directory URL
+//	cp URL PATH                 download the URL to a local file
+//	resolve [-base URL]... PATH show how PATH resolves against the bases
+//	validate URL                check that the URL can be opened
+//	format URL                  show the URL's format
+//
+// Arguments that are not URLs are treated as local file paths.
+package main
+
+import (
+	contextpkg "context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tliron/exturl"
+)
+
+//
+// listFlag
+//
+
+type listFlag []string
+
+// ([flag.Value] interface)
+func (self *listFlag) String() string {
+	return strings.Join(*self, ",")
+}
+
+// ([flag.Value] interface)
+func (self *listFlag) Set(value string) error {
+	*self = append(*self, value)
+	return nil
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	var credentials, mappings listFlag
+	flag.Var(&credentials, "credentials", "`HOST=USER:PASSWORD` or HOST=TOKEN (can be repeated)")
+	flag.Var(&mappings, "map", "map `FROM=TO` URLs (can be repeated)")
+	offline := flag.Bool("offline", false, "fail instead of accessing the network")
+	cacheDir := flag.String("cache-dir", "", "`DIR` in which downloads and clones are kept and reused by later runs")
+	temporaryDir := flag.String("temporary-dir", "", "`DIR` for downloads and clones when there is no cache dir, which are deleted on exit (default is the OS's temporary directory)")
+	verbose := flag.Bool("verbose", false, "print events to stderr")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	urlContext := exturl.NewContext()
+
+	for _, credentials_ := range credentials {
+		host, value, ok := strings.Cut(credentials_, "=")
+		if !ok {
+			fail(fmt.Errorf("malformed credentials: %s", credentials_))
+		}
+		if username, password, ok := strings.Cut(value, ":"); ok {
+			urlContext.SetCredentials(host, username, password, "")
+		} else {
+			urlContext.SetCredentials(host, "", "", value)
+		}
+	}
+
+	for _, mapping := range mappings {
+		if fromUrl, toUrl, ok := strings.Cut(mapping, "="); ok {
+			urlContext.Map(fromUrl, toUrl)
+		} else {
+			fail(fmt.Errorf("malformed mapping: %s", mapping))
+		}
+	}
+
+	urlContext.SetOffline(*offline)
+	urlContext.SetCacheDir(*cacheDir)
+	urlContext.SetTemporaryDir(*temporaryDir)
+
+	if *verbose {
+		urlContext.AddObserver(exturl.ObserverFunc(func(event *exturl.Event) {
+			message := event.Type.String()
+			if event.URL != nil {
+				message += " " + event.URL.String()
+			}
+			if event.Message != "" {
+				message += ": " + event.Message
+			}
+			if event.Error != nil {
+				message += ": " + event.Error.Error()
+			}
+			fmt.Fprintln(os.Stderr, message)
+		}))
+	}
+
+	err := run(contextpkg.Background(), urlContext, flag.Arg(0), flag.Args()[1:])
+	urlContext.Release()
+
+	if errors.Is(err, errUsage) {
+		usage()
+		os.Exit(2)
+	} else if err != nil {
+		fail(err)
+	}
+}
+
+func run(context contextpkg.Context, urlContext *exturl.Context, command string, arguments []string) error {
+	switch command {
+	case "cat":
+		if len(arguments) == 0 {
+			return errUsage
+		}
+		return cat(context, urlContext, arguments)
+
+	case "stat":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		return stat(context, urlContext, arguments[0])
+
+	case "ls":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		return ls(context, urlContext, arguments[0])
+
+	case "cp":
+		if len(arguments) != 2 {
+			return errUsage
+		}
+		return cp(context, urlContext, arguments[0], arguments[1])
+
+	case "resolve":
+		return resolve(context, urlContext, arguments)
+
+	case "validate":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		return validate(context, urlContext, arguments[0])
+
+	case "format":
+		if len(arguments) != 1 {
+			return errUsage
+		}
+		return format(urlContext, arguments[0])
+
+	default:
+		return errUsage
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] COMMAND [ARGUMENTS]
+
+Commands:
+  cat URL...                  write the content of the URLs to stdout
+  stat URL                    show the URL's key, format, and size
+  ls URL                      list the entries of a directory URL
+  cp URL PATH                 download the URL to a local file
+  resolve [-base URL]... PATH show how PATH resolves against the bases
+  validate URL                check that the URL can be opened
+  format URL                  show the URL's format
+
+Arguments that are not URLs are treated as local file paths.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err.Error())
+	os.Exit(1)
+}
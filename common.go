@@ -2,9 +2,13 @@ package exturl
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/tliron/commonlog"
 	"github.com/tliron/kutil/util"
@@ -35,6 +39,15 @@ func GetFormat(path string) string {
 
 	case "tgz":
 		return "tar.gz"
+
+	case "txz":
+		return "tar.xz"
+
+	case "tzst":
+		return "tar.zst"
+
+	case "tbz2", "tbz":
+		return "tar.bz2"
 	}
 
 	return extension
@@ -47,9 +60,11 @@ func GetTemporaryPathPattern(key string) string {
 func DeleteTemporaryFile(path string) error {
 	if err := os.Remove(path); err == nil {
 		log.Infof("deleted temporary file %q", path)
+		deletePidFile(path)
 		return nil
 	} else if os.IsNotExist(err) {
 		log.Infof("temporary file already deleted %q", path)
+		deletePidFile(path)
 		return nil
 	} else {
 		log.Errorf("could not delete temporary file %q: %s", path, err.Error())
@@ -60,12 +75,270 @@ func DeleteTemporaryFile(path string) error {
 func DeleteTemporaryDir(path string) error {
 	if err := os.RemoveAll(path); err == nil {
 		log.Infof("deleted temporary dir %q", path)
+		deletePidFile(path)
 		return nil
 	} else if os.IsNotExist(err) {
 		log.Infof("temporary dir already deleted %q", path)
+		deletePidFile(path)
 		return nil
 	} else {
 		log.Errorf("could not delete temporary dir %q: %s", path, err.Error())
 		return err
 	}
 }
+
+// Splits "ARCHIVE!PATH" on the last unescaped "!", so that ARCHIVE can itself
+// be an archive URL, e.g. "tar:https://site.org/bundle.tgz!inner.zip" in
+// "zip:tar:https://site.org/bundle.tgz!inner.zip!/doc.yaml".
+//
+// A literal "!" in PATH is escaped as "!!". ARCHIVE is returned as is, because
+// it is parsed in turn, while PATH is unescaped. In a run of an odd number of
+// "!", the last one is the separator, thus PATH cannot begin with a literal "!"
+// unless it begins with "/", as it does in keys.
+func splitArchiveURL(url string) (string, string, bool) {
+	for end := len(url); end > 0; {
+		if url[end-1] != '!' {
+			end--
+			continue
+		}
+
+		start := end - 1
+		for (start > 0) && (url[start-1] == '!') {
+			start--
+		}
+
+		if (end-start)%2 == 1 {
+			return url[:end-1], strings.ReplaceAll(url[end:], "!!", "!"), true
+		}
+
+		end = start
+	}
+
+	return "", "", false
+}
+
+// See splitArchiveURL.
+func escapeArchivePath(path string) string {
+	return strings.ReplaceAll(path, "!", "!!")
+}
+
+// Archive paths are relative to the archive root, which is an empty string
+func getArchiveBasePath(path string) string {
+	switch path = pathpkg.Dir(path); path {
+	case ".", "/":
+		return ""
+	default:
+		return path + "/"
+	}
+}
+
+// Maps each dir in the archive entry names, including implied dirs, to its direct
+// children as returned by listArchiveEntries. The root dir is an empty string.
+func getArchiveDirs(names []string) map[string][]string {
+	children := map[string]map[string]struct{}{"": {}}
+	addDir := func(dir string) map[string]struct{} {
+		if children_, ok := children[dir]; ok {
+			return children_
+		}
+		children_ := make(map[string]struct{})
+		children[dir] = children_
+		return children_
+	}
+
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "./")
+
+		// Dir entries are dirs even if they have no children
+		if dir := strings.TrimSuffix(name, "/"); (dir != name) && (dir != "") {
+			addDir(dir)
+		}
+
+		for path := name; ; {
+			child := strings.TrimSuffix(path, "/")
+			if child == "" {
+				break
+			}
+
+			var dir string
+			if slash := strings.LastIndex(child, "/"); slash != -1 {
+				dir = child[:slash]
+			}
+
+			addDir(dir)[path] = struct{}{}
+
+			if dir == "" {
+				break
+			}
+			path = dir + "/"
+		}
+	}
+
+	dirs := make(map[string][]string, len(children))
+	for dir, children_ := range children {
+		paths := make([]string, 0, len(children_))
+		for path := range children_ {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		dirs[dir] = paths
+	}
+
+	return dirs
+}
+
+// Returns the direct children of "dir" among the archive entry names, with a
+// trailing "/" for directories. Returns false if "dir" is not in the archive.
+func listArchiveEntries(dir string, names []string) ([]string, bool) {
+	dir = strings.Trim(dir, "/")
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	found := dir == ""
+	children := make(map[string]struct{})
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "./")
+		if strings.HasPrefix(name, prefix) {
+			found = true
+			if rest := name[len(prefix):]; rest != "" {
+				if slash := strings.Index(rest, "/"); slash != -1 {
+					children[prefix+rest[:slash+1]] = struct{}{}
+				} else {
+					children[prefix+rest] = struct{}{}
+				}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(children))
+	for path := range children {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths, found
+}
+
+// Maximum number of links followed when resolving a path in an archive (like
+// Linux's limit for symlinks), beyond which we assume a loop.
+const ARCHIVE_MAX_LINKS = 40
+
+// Returns the target of the link at the path, and whether it is relative to the
+// archive root (hardlinks) rather than to the link's dir (symlinks). "ok" is
+// false if the path is not a link.
+type archiveLinkFunc func(path string) (target string, hard bool, ok bool, err error)
+
+// Follows links in all the components of the path, except for the last one if
+// "followLast" is false. Absolute symlink targets are relative to the archive
+// root. Returns a [Forbidden] error if a link escapes the archive root and a
+// [Malformed] error if there are too many links (a loop).
+//
+// A trailing "/" is kept.
+func resolveArchivePath(key string, path string, followLast bool, getLink archiveLinkFunc) (string, error) {
+	pending := strings.Split(path, "/")
+	var resolved []string
+	var links int
+
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+
+		switch component {
+		case "", ".":
+			continue
+
+		case "..":
+			if len(resolved) == 0 {
+				return "", &Forbidden{newURLError(key, nil, "path escapes the archive root: %s", key)}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		current := pathpkg.Join(strings.Join(resolved, "/"), component)
+
+		if followLast || (len(pending) > 0) {
+			if target, hard, ok, err := getLink(current); err == nil {
+				if ok {
+					if links++; links > ARCHIVE_MAX_LINKS {
+						return "", &Malformed{newURLError(key, nil, "too many links in path: %s", key)}
+					}
+
+					if hard || strings.HasPrefix(target, "/") {
+						resolved = nil
+					}
+					pending = append(strings.Split(target, "/"), pending...)
+					continue
+				}
+			} else {
+				return "", err
+			}
+		}
+
+		resolved = append(resolved, component)
+	}
+
+	resolvedPath := strings.Join(resolved, "/")
+	if (resolvedPath != "") && strings.HasSuffix(path, "/") {
+		resolvedPath += "/"
+	}
+	return resolvedPath, nil
+}
+
+// Children of a dir that was reached via a link are reported as under the link.
+func relinkArchivePaths(paths []string, dir string, resolvedDir string) []string {
+	dir = strings.Trim(dir, "/")
+	resolvedDir = strings.Trim(resolvedDir, "/")
+	if dir == resolvedDir {
+		return paths
+	}
+
+	relinked := make([]string, len(paths))
+	for index, path := range paths {
+		relinked[index] = pathpkg.Join(dir, strings.TrimPrefix(path, resolvedDir+"/"))
+		if strings.HasSuffix(path, "/") {
+			relinked[index] += "/"
+		}
+	}
+	return relinked
+}
+
+//
+// archiveDirInfo
+//
+
+// For dirs that have no entries of their own in the archive.
+type archiveDirInfo struct {
+	path string
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) Name() string {
+	return pathpkg.Base(self.path)
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) Size() int64 {
+	return 0
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) Mode() fs.FileMode {
+	return fs.ModeDir | 0755
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) IsDir() bool {
+	return true
+}
+
+// ([fs.FileInfo] interface)
+func (self archiveDirInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,98 @@
+package exturl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArchiveURL(t *testing.T) {
+	for url, expected := range map[string][2]string{
+		"http://site.org/a.tgz!dir/b.yaml":               {"http://site.org/a.tgz", "dir/b.yaml"},
+		"tar:http://site.org/a.tgz!inner.zip!/b.yaml":    {"tar:http://site.org/a.tgz!inner.zip", "/b.yaml"},
+		"http://site.org/a.tgz!dir/b!!.yaml":             {"http://site.org/a.tgz", "dir/b!.yaml"},
+		"tar:http://site.org/a.tgz!/dir!!!/b.yaml":       {"tar:http://site.org/a.tgz!/dir!!", "/b.yaml"},
+		"http://site.org/a.tgz!!!/b.yaml":                {"http://site.org/a.tgz!!", "/b.yaml"},
+		"http://site.org/a.tgz!":                         {"http://site.org/a.tgz", ""},
+		"git:https://github.com/user/repo.git!a.tgz!b!!": {"git:https://github.com/user/repo.git!a.tgz", "b!"},
+	} {
+		if archiveUrl, path, ok := splitArchiveURL(url); !ok {
+			t.Errorf("%q: not split", url)
+		} else if (archiveUrl != expected[0]) || (path != expected[1]) {
+			t.Errorf("%q: %q, %q", url, archiveUrl, path)
+		}
+	}
+
+	for _, url := range []string{"http://site.org/a.tgz", "http://site.org/a!!.tgz"} {
+		if _, _, ok := splitArchiveURL(url); ok {
+			t.Errorf("%q: split", url)
+		}
+	}
+}
+
+func TestGetArchiveDirs(t *testing.T) {
+	names := []string{"a.yaml", "./dir/b.json", "dir/sub/c.txt", "empty/", "implied/deep/d.txt"}
+	dirs := getArchiveDirs(names)
+
+	for _, dir := range []string{"", "dir", "dir/sub", "empty", "implied", "implied/deep", "missing"} {
+		paths, found := listArchiveEntries(dir, names)
+		if paths_, found_ := dirs[dir]; found_ != found {
+			t.Errorf("%q: found %t != %t", dir, found_, found)
+		} else if found && !reflect.DeepEqual(paths_, paths) {
+			t.Errorf("%q: %v != %v", dir, paths_, paths)
+		}
+	}
+}
+
+func TestResolveArchivePath(t *testing.T) {
+	links := map[string][2]string{
+		"current":     {"v1.2", ""},
+		"latest.yaml": {"current/main.yaml", ""},
+		"abs":         {"/v1.2/main.yaml", ""},
+		"v1.2/up":     {"../other", ""},
+		"hard.yaml":   {"v1.2/main.yaml", "hard"},
+		"escape":      {"../etc/passwd", ""},
+		"loop1":       {"loop2", ""},
+		"loop2":       {"loop1", ""},
+	}
+
+	getLink := func(path string) (string, bool, bool, error) {
+		if link, ok := links[path]; ok {
+			return link[0], link[1] == "hard", true, nil
+		}
+		return "", false, false, nil
+	}
+
+	for path, expected := range map[string]string{
+		"v1.2/main.yaml":         "v1.2/main.yaml",
+		"current/main.yaml":      "v1.2/main.yaml",
+		"./current/../a.yaml":    "a.yaml",
+		"latest.yaml":            "v1.2/main.yaml",
+		"abs":                    "v1.2/main.yaml",
+		"current/up/b.yaml":      "other/b.yaml",
+		"hard.yaml":              "v1.2/main.yaml",
+		"current/":               "v1.2/",
+		"":                       "",
+		"missing/current/a.yaml": "missing/current/a.yaml",
+	} {
+		if resolved, err := resolveArchivePath(path, path, true, getLink); err != nil {
+			t.Errorf("%q: %s", path, err.Error())
+		} else if resolved != expected {
+			t.Errorf("%q: %q", path, resolved)
+		}
+	}
+
+	// The last component is not followed
+	if resolved, err := resolveArchivePath("current/up", "current/up", false, getLink); err != nil {
+		t.Errorf("lstat: %s", err.Error())
+	} else if resolved != "v1.2/up" {
+		t.Errorf("lstat: %q", resolved)
+	}
+
+	if _, err := resolveArchivePath("escape", "escape", true, getLink); !IsForbidden(err) {
+		t.Errorf("escape: %v", err)
+	}
+
+	if _, err := resolveArchivePath("loop1/a.yaml", "loop1/a.yaml", true, getLink); !IsMalformed(err) {
+		t.Errorf("loop: %v", err)
+	}
+}
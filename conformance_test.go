@@ -0,0 +1,204 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestConformanceFile(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		dir := t.TempDir()
+		exturltest.WriteDir(t, dir)
+		return urlContext.NewFileURL(filepath.Join(dir, "a.yaml"))
+	})
+}
+
+func TestConformanceInternal(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		root := "/exturltest/" + t.Name()
+		for path, content := range exturltest.Files {
+			exturl.UpdateInternalURL(root+"/"+path, content)
+			t.Cleanup(func() {
+				exturl.DeregisterInternalURL(root + "/" + path)
+			})
+		}
+		return urlContext.NewInternalURL(root + "/a.yaml")
+	})
+}
+
+func TestConformanceMockFS(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		fs := exturl.NewMockFS()
+		for path, content := range exturltest.Files {
+			fs.Add(path, content)
+		}
+		urlContext.MountMockFS("https://mock.example", fs)
+		return newURL(t, urlContext, "https://mock.example/a.yaml")
+	})
+}
+
+func TestConformanceTarball(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		path := writeFile(t, "tree.tar", exturltest.Tarball(t, false))
+		return newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+	})
+}
+
+func TestConformanceGzipTarball(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		path := writeFile(t, "tree.tar.gz", exturltest.Tarball(t, true))
+		return newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+	})
+}
+
+func TestConformanceCompressedTarball(t *testing.T) {
+	for _, archiveFormat := range exturl.TARBALL_ARCHIVE_FORMATS {
+		t.Run(archiveFormat, func(t *testing.T) {
+			exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+				path := writeFile(t, "tree."+archiveFormat, exturltest.CompressedTarball(t, archiveFormat))
+				return newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+			})
+		})
+	}
+}
+
+func TestConformanceEstargz(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		path := writeFile(t, "tree.tar.gz", exturltest.Estargz(t, exturltest.Tarball(t, false), 0))
+		return newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+	})
+}
+
+func TestConformanceZip(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		path := writeFile(t, "tree.zip", exturltest.Zip(t))
+		return newURL(t, urlContext, "zip:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+	})
+}
+
+func TestConformanceNestedZip(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		path := writeFile(t, "outer.tar", exturltest.TarballOf(t, "tar", exturltest.TarballFile("inner.zip", exturltest.Zip(t))))
+		return newURL(t, urlContext, "zip:tar:"+urlContext.NewFileURL(path).String()+"!inner.zip!a.yaml")
+	})
+}
+
+func TestConformanceNestedTarball(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		inner := exturltest.TarballOf(t, "tar", exturltest.TarballFile("dir!/tree.tar.gz", exturltest.Tarball(t, true)))
+		path := writeFile(t, "outer.tar", exturltest.TarballOf(t, "tar", exturltest.TarballFile("middle.tar", inner)))
+		return newURL(t, urlContext, "tar:tar:tar:"+urlContext.NewFileURL(path).String()+"!middle.tar!dir!!/tree.tar.gz!a.yaml")
+	})
+}
+
+func TestConformanceNetwork(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		return newURL(t, urlContext, startFileServer(t)+"/a.yaml")
+	})
+}
+
+func TestConformanceNetworkTarball(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		return newURL(t, urlContext, "tar:"+startFileServer(t)+"/tree.tar.gz!a.yaml")
+	})
+}
+
+func TestConformanceNetworkZip(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		return newURL(t, urlContext, "zip:"+startFileServer(t)+"/tree.zip!a.yaml")
+	})
+}
+
+func TestConformanceGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		// go-git's "file:" transport requires the git binary
+		t.Skip("git not installed")
+	}
+
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		dir := t.TempDir()
+		exturltest.GitRepository(t, dir)
+		return newURL(t, urlContext, "git:"+urlContext.NewFileURL(dir).String()+"!a.yaml")
+	})
+}
+
+func TestConformanceDocker(t *testing.T) {
+	exturltest.Run(t, func(t *testing.T, urlContext *exturl.Context) exturl.URL {
+		server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+		t.Cleanup(server.Close)
+
+		host := server.Listener.Addr().String()
+		tag, err := name.NewTag(host + "/exturltest/tree:latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content := exturltest.Tarball(t, false)
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		image, err := mutate.AppendLayers(empty.Image, layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := remote.Write(tag, image); err != nil {
+			t.Fatal(err)
+		}
+
+		return newURL(t, urlContext, "tar:docker://"+host+"/exturltest/tree:latest?format=tar!a.yaml")
+	})
+}
+
+// Serves the tree as files, and also as "tree.tar.gz" and "tree.zip"
+func startFileServer(t *testing.T) string {
+	dir := t.TempDir()
+	exturltest.WriteDir(t, dir)
+	writeFile(t, filepath.Join(dir, "tree.tar.gz"), exturltest.Tarball(t, true))
+	writeFile(t, filepath.Join(dir, "tree.zip"), exturltest.Zip(t))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func writeFile(t *testing.T, path string, content []byte) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.TempDir(), path)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newURL(t *testing.T, urlContext *exturl.Context, url string) exturl.URL {
+	if url_, err := urlContext.NewURL(url); err == nil {
+		return url_
+	} else {
+		t.Fatal(err)
+		return nil
+	}
+}
@@ -2,9 +2,13 @@ package exturl
 
 import (
 	contextpkg "context"
+	"fmt"
+	"io"
+	"maps"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/tliron/kutil/util"
 )
@@ -22,39 +26,97 @@ type Credentials struct {
 type URLTransformerFunc func(fromUrl string) (string, bool)
 
 type Context struct {
+	parent            *Context
 	transformers      []URLTransformerFunc
 	mappings          map[string]string
-	files             map[string]string
-	dirs              map[string]string
 	httpRoundTrippers map[string]http.RoundTripper
 	credentials       map[string]*Credentials
-	lock              sync.Mutex // for files
+	mockMounts        map[string]*MockFS
+	observers         []Observer
+	metrics           Metrics
+	tracer            Tracer
+	lockfile          *Lockfile
+	lockfileMode      LockfileMode
+	faults            *Faults
+	offline           bool
+	temporaryDir      string
+	cacheDir          string
+	cleanStaleFiles   bool
+	cleanedStaleFiles sync.Once
+	quota             *temporaryQuota
+	files             map[string]*temporaryEntry
+	dirs              map[string]*temporaryEntry
+	internalPaths     []string
+	zipIndexes        map[string]*ZipIndex
+	tarIndexes        map[string]*TarIndex
+	prefetched        map[string][]byte
+	prefetchedSize    int64
+	releases          int
+	downloads         flightGroup
+	clones            flightGroup
+	zipIndexFlights   flightGroup
+	tarIndexFlights   flightGroup
+	lock              sync.Mutex // for files, dirs, internalPaths, zipIndexes, tarIndexes, prefetched, prefetchedSize, and releases
 }
 
 func NewContext() *Context {
-	var self Context
-	self.transformers = []URLTransformerFunc{self.GetMapping}
-	return &self
+	return new(Context)
 }
 
+// Creates a child context that inherits this context's transformers, mappings,
+// HTTP round trippers, and credentials. Changing these in the child will not affect
+// this context (copy-on-write). Note that changes in this context will be visible
+// in the child only until the child makes its own changes.
+//
+// The child has its own temporary files and dirs, which are deleted when the child
+// is released. However, the child will reuse files that have already been
+// downloaded and repositories that have already been cloned by this context (or
+// its ancestors). These are reference counted, so they will not be deleted by
+// [Context.Release] on this context while the child is still using them.
+func (self *Context) NewChild() *Context {
+	return &Context{
+		parent: self,
+	}
+}
+
+// Returns nil if this is not a child context.
+func (self *Context) Parent() *Context {
+	return self.parent
+}
+
+// Mappings are tried first, then transformers in the order in which they were added.
 func (self *Context) Transform(fromUrl string) (string, bool) {
-	for _, transformer := range self.transformers {
+	if toUrl, ok := self.GetMapping(fromUrl); ok {
+		return toUrl, true
+	}
+
+	for _, transformer := range self.getTransformers() {
 		if toUrl, ok := transformer(fromUrl); ok {
 			return toUrl, true
 		}
 	}
+
 	return "", false
 }
 
+// Not thread-safe
 func (self *Context) AddTransformer(transformer URLTransformerFunc) {
+	if (self.transformers == nil) && (self.parent != nil) {
+		// Copy on write
+		self.transformers = append([]URLTransformerFunc(nil), self.parent.getTransformers()...)
+	}
+
 	self.transformers = append(self.transformers, transformer)
 }
 
 // Set toUrl to empty string to delete the mapping.
+//
+// fromUrl can be either the URL as provided to [Context.NewURL] or its
+// normalized form as returned by [URL.Key].
+//
+// Not thread-safe
 func (self *Context) Map(fromUrl string, toUrl string) {
-	if self.mappings == nil {
-		self.mappings = make(map[string]string)
-	}
+	self.mappings = copyOnWrite(self.mappings, self.parent, (*Context).getMappings)
 
 	if toUrl == "" {
 		delete(self.mappings, fromUrl)
@@ -65,38 +127,40 @@ func (self *Context) Map(fromUrl string, toUrl string) {
 
 // URLTransformerFunc signature
 func (self *Context) GetMapping(fromUrl string) (string, bool) {
-	if self.mappings == nil {
+	if mappings := self.getMappings(); mappings != nil {
+		toUrl, ok := mappings[fromUrl]
+		return toUrl, ok
+	} else {
 		return "", false
 	}
-	toUrl, ok := self.mappings[fromUrl]
-	return toUrl, ok
 }
 
+// Used by "http:", "https:", and "docker:" URLs for the host. The host includes
+// the port, if the URL has one.
+//
 // Not thread-safe
 func (self *Context) SetHTTPRoundTripper(host string, httpRoundTripper http.RoundTripper) {
-	if self.httpRoundTrippers == nil {
-		self.httpRoundTrippers = make(map[string]http.RoundTripper)
-	}
-
+	self.httpRoundTrippers = copyOnWrite(self.httpRoundTrippers, self.parent, (*Context).getHTTPRoundTrippers)
 	self.httpRoundTrippers[host] = httpRoundTripper
 }
 
 // Not thread-safe
 func (self *Context) GetHTTPRoundTripper(host string) http.RoundTripper {
-	if self.httpRoundTrippers != nil {
-		httpRoundTripper, _ := self.httpRoundTrippers[host]
+	if httpRoundTrippers := self.getHTTPRoundTrippers(); httpRoundTrippers != nil {
+		httpRoundTripper, _ := httpRoundTrippers[host]
 		return httpRoundTripper
 	} else {
 		return nil
 	}
 }
 
+// Used by "http:", "https:", and "docker:" URLs for the host. For
+// "http:" and "https:" URLs a token is sent as a bearer token, otherwise the
+// username and password are sent via basic authentication.
+//
 // Not thread-safe
 func (self *Context) SetCredentials(host string, username string, password string, token string) {
-	if self.credentials == nil {
-		self.credentials = make(map[string]*Credentials)
-	}
-
+	self.credentials = copyOnWrite(self.credentials, self.parent, (*Context).getCredentials)
 	self.credentials[host] = &Credentials{
 		Username: username,
 		Password: password,
@@ -106,14 +170,118 @@ func (self *Context) SetCredentials(host string, username string, password strin
 
 // Not thread-safe
 func (self *Context) GetCredentials(host string) *Credentials {
-	if self.credentials != nil {
-		credentials, _ := self.credentials[host]
-		return credentials
+	if credentials := self.getCredentials(); credentials != nil {
+		credentials_, _ := credentials[host]
+		return credentials_
 	} else {
 		return nil
 	}
 }
 
+// When offline, "http:", "https:", and "docker:" URLs, as well as "git:" URLs for
+// remote repositories, fail with [*Offline] instead of accessing the network.
+// Content that is already available locally, e.g. previously cloned
+// repositories and downloaded files, can still be used.
+//
+// Child contexts are offline if their parent is.
+//
+// Not thread-safe
+func (self *Context) SetOffline(offline bool) {
+	self.offline = offline
+}
+
+func (self *Context) IsOffline() bool {
+	for context := self; context != nil; context = context.parent {
+		if context.offline {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the directory in which downloaded files and cloned repositories are stored.
+// An empty string (the default) means the OS's default temporary directory.
+//
+// Child contexts inherit the directory unless they set their own.
+//
+// Not thread-safe
+func (self *Context) SetTemporaryDir(path string) {
+	self.temporaryDir = path
+}
+
+// Not thread-safe
+func (self *Context) GetTemporaryDir() string {
+	if (self.temporaryDir == "") && (self.parent != nil) {
+		return self.parent.GetTemporaryDir()
+	}
+	return self.temporaryDir
+}
+
+// Sets a limit in bytes on the total size of files downloaded and repositories cloned
+// by this context and its children. Exceeding the quota will result in a
+// [*QuotaExceeded] error.
+//
+// When a quota is set, temporary files and dirs released by child contexts are not
+// deleted immediately. Instead they are kept for reuse by other children until their
+// space is needed for new downloads, at which point the least recently released are
+// deleted first. They are all deleted when this context is released.
+//
+// Note that cloned repositories can only be measured after the clone completes.
+//
+// Should be called before any downloads or clones. Not thread-safe.
+func (self *Context) SetQuota(quota int64) {
+	self.quota = newTemporaryQuota(quota)
+}
+
+// All [URL.Open] implementations go through here.
+func (self *Context) open(context contextpkg.Context, url URL, open func(context contextpkg.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	start := time.Now()
+	context, countError := countErrorOnce(context)
+	randomAccess := isRandomAccessOpen(context)
+	if faults := self.GetFaults(); (faults != nil) && !randomAccess {
+		open_ := open
+		open = func(context contextpkg.Context) (io.ReadCloser, error) {
+			return faults.open(context, open_)
+		}
+	}
+
+	err := self.trace(context, "exturl.Open", url, func(context contextpkg.Context) error {
+		var err error
+		reader, err = open(context)
+		return err
+	})
+
+	if metrics := self.GetMetrics(); metrics != nil {
+		scheme := GetScheme(url)
+		metrics.ObserveOpenLatency(scheme, time.Since(start))
+		if err == nil {
+			if !randomAccess {
+				reader = forwardSeek(&metricsReader{reader, metrics, scheme}, reader)
+			}
+		} else if countError {
+			metrics.AddError(scheme, GetErrorType(err))
+		}
+	}
+
+	if err == nil {
+		if lockfile, mode := self.GetLockfile(); (lockfile != nil) && !isNestedOpen(context) {
+			if randomAccess {
+				err = lockfile.hashRandomAccess(context, url, mode, reader)
+			} else {
+				reader, err = lockfile.wrap(context, url, mode, reader)
+			}
+
+			if (err != nil) && countError {
+				self.countError(url, err)
+			}
+		}
+	}
+
+	self.emitType(EventOpen, url, "", err)
+	return reader, err
+}
+
 func (self *Context) OpenFile(context contextpkg.Context, url URL) (*os.File, error) {
 	if path, err := self.GetLocalPath(context, url); err == nil {
 		return os.Open(path)
@@ -122,7 +290,13 @@ func (self *Context) OpenFile(context contextpkg.Context, url URL) (*os.File, er
 	}
 }
 
-// Will download the file to the local temporary directory if not already locally available
+// Will download the file to the local temporary directory (or the cache dir, see
+// [Context.SetCacheDir]) if not already locally available.
+//
+// Concurrent calls for the same URL will share a single download, while calls for
+// different URLs proceed in parallel. Cancelling the context will stop this call from
+// waiting, but the shared download will only be cancelled if no other calls are waiting
+// for it.
 func (self *Context) GetLocalPath(context contextpkg.Context, url URL) (string, error) {
 	if fileUrl, ok := url.(*FileURL); ok {
 		// No need to download file URLs
@@ -131,60 +305,269 @@ func (self *Context) GetLocalPath(context contextpkg.Context, url URL) (string,
 
 	key := url.Key()
 
-	self.lock.Lock()
-	defer self.lock.Unlock()
+	if entry, err := self.getTemporaryEntry(key, false); err == nil {
+		if entry != nil {
+			self.cacheLookup(url, true, CacheKindFile)
+			return entry.path, nil
+		}
+	} else {
+		return "", err
+	}
 
-	if self.files != nil {
-		if path, ok := self.files[key]; ok {
-			if ok, err := util.DoesFileExist(path); err == nil {
-				if ok {
-					return path, nil
+	if path, err := self.getCached(key); err == nil {
+		if path != "" {
+			self.cacheLookup(url, true, CacheKindFile)
+			return path, nil
+		}
+	} else {
+		return "", err
+	}
+
+	self.cacheLookup(url, false, CacheKindFile)
+
+	if path, err := self.downloads.Do(context, key, func(context contextpkg.Context) (any, error) {
+		// Another download might have completed in the meantime
+		if entry, err := self.getTemporaryEntry(key, false); err == nil {
+			if entry != nil {
+				return entry.path, nil
+			}
+		} else {
+			return nil, err
+		}
+
+		if path, err := self.getCached(key); err == nil {
+			if path != "" {
+				return path, nil
+			}
+		} else {
+			return nil, err
+		}
+
+		if self.GetCacheDir() != "" {
+			if cacheDir, err := self.getCacheDir(); err == nil {
+				if file, _, err := download(context, self, url, cacheDir, GetTemporaryPathPattern(key), nil); err == nil {
+					file.Close()
+					return moveToCache(file.Name(), cacheDir, key, false)
 				} else {
-					delete(self.files, key)
+					return nil, err
 				}
 			} else {
-				return "", err
+				return nil, err
 			}
 		}
-	}
 
-	if file, err := Download(context, url, GetTemporaryPathPattern(key)); err == nil {
-		if self.files == nil {
-			self.files = make(map[string]string)
+		quota := self.getQuota()
+		if file, size, err := download(context, self, url, self.getCleanTemporaryDir(), GetTemporaryPathPattern(key), quota); err == nil {
+			file.Close()
+			entry := self.addTemporaryEntry(key, newTemporaryEntry(key, file.Name(), false, size, quota))
+			return entry.path, nil
+		} else {
+			return nil, err
 		}
-		path := file.Name()
-		self.files[key] = path
-		return path, nil
+	}); err == nil {
+		return path.(string), nil
 	} else {
 		return "", err
 	}
 }
 
+// Deletes temporary files and dirs unless they are still used by child contexts or
+// kept for reuse by a quota (see [Context.SetQuota]).
 func (self *Context) Release() error {
 	self.lock.Lock()
 	defer self.lock.Unlock()
 
 	var err error
 
-	if self.files != nil {
-		for _, path := range self.files {
-			if err_ := DeleteTemporaryFile(path); err_ != nil {
+	// Indexes may be reading from temporary files, so we close them first
+	for _, zipIndex := range self.zipIndexes {
+		if err_ := zipIndex.Close(); err_ != nil {
+			err = err_
+		}
+	}
+	self.zipIndexes = nil
+
+	for _, tarIndex := range self.tarIndexes {
+		if tarIndex != nil {
+			if err_ := tarIndex.Close(); err_ != nil {
 				err = err_
 			}
 		}
+	}
+	self.tarIndexes = nil
 
-		self.files = nil
+	if quota := self.getQuota(); quota != nil {
+		quota.free(self.prefetchedSize)
 	}
+	self.prefetched = nil
+	self.prefetchedSize = 0
 
-	if self.dirs != nil {
-		for _, path := range self.dirs {
-			if err_ := DeleteTemporaryDir(path); err_ != nil {
-				err = err_
-			}
+	for _, entry := range self.files {
+		if err_ := entry.release(); err_ != nil {
+			err = err_
+		}
+	}
+	self.files = nil
+
+	for _, entry := range self.dirs {
+		if err_ := entry.release(); err_ != nil {
+			err = err_
 		}
+	}
+	self.dirs = nil
+
+	for _, path := range self.internalPaths {
+		DeregisterInternalURL(path)
+	}
+	self.internalPaths = nil
 
-		self.files = nil
+	// So that indexes that are being created will not be stored
+	self.releases++
+
+	if self.quota != nil {
+		if err_ := self.quota.close(); err_ != nil {
+			err = err_
+		}
 	}
 
 	return err
 }
+
+// For work on behalf of a context that was released before it completed.
+func newReleasedError(key string) error {
+	return fmt.Errorf("context released: %s: %w", key, contextpkg.Canceled)
+}
+
+func (self *Context) getQuota() *temporaryQuota {
+	if (self.quota == nil) && (self.parent != nil) {
+		return self.parent.getQuota()
+	}
+	return self.quota
+}
+
+func (self *Context) getTransformers() []URLTransformerFunc {
+	if (self.transformers == nil) && (self.parent != nil) {
+		return self.parent.getTransformers()
+	}
+	return self.transformers
+}
+
+func (self *Context) getMappings() map[string]string {
+	if (self.mappings == nil) && (self.parent != nil) {
+		return self.parent.getMappings()
+	}
+	return self.mappings
+}
+
+func (self *Context) getHTTPRoundTrippers() map[string]http.RoundTripper {
+	if (self.httpRoundTrippers == nil) && (self.parent != nil) {
+		return self.parent.getHTTPRoundTrippers()
+	}
+	return self.httpRoundTrippers
+}
+
+func (self *Context) getCredentials() map[string]*Credentials {
+	if (self.credentials == nil) && (self.parent != nil) {
+		return self.parent.getCredentials()
+	}
+	return self.credentials
+}
+
+// Looks in this context first, then in its ancestors, and finally in the entries kept
+// by the quota. Entries found elsewhere are retained and added to this context.
+//
+// Returns nil if not found.
+func (self *Context) getTemporaryEntry(key string, dir bool) (*temporaryEntry, error) {
+	if entry, err := self.findTemporaryEntry(key, dir); err == nil {
+		if entry != nil {
+			return entry, nil
+		}
+	} else {
+		return nil, err
+	}
+
+	for parent := self.parent; parent != nil; parent = parent.parent {
+		if entry, err := parent.findTemporaryEntry(key, dir); err == nil {
+			if (entry != nil) && entry.retain() {
+				return self.addTemporaryEntry(key, entry), nil
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	if quota := self.getQuota(); quota != nil {
+		if entry := quota.revive(key, dir); entry != nil {
+			return self.addTemporaryEntry(key, entry), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Looks in this context only.
+//
+// Returns nil if not found.
+func (self *Context) findTemporaryEntry(key string, dir bool) (*temporaryEntry, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entries := self.files
+	if dir {
+		entries = self.dirs
+	}
+
+	if entry, ok := entries[key]; ok {
+		if ok, err := util.DoesFileExist(entry.path); err == nil {
+			if ok {
+				return entry, nil
+			} else {
+				delete(entries, key)
+				entry.release()
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// If an entry already exists for the key then the argument is released and the
+// existing entry is returned instead.
+func (self *Context) addTemporaryEntry(key string, entry *temporaryEntry) *temporaryEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var entries *map[string]*temporaryEntry
+	if entry.dir {
+		entries = &self.dirs
+	} else {
+		entries = &self.files
+	}
+
+	if *entries == nil {
+		*entries = make(map[string]*temporaryEntry)
+	} else if existing, ok := (*entries)[key]; ok {
+		entry.release()
+		return existing
+	}
+
+	(*entries)[key] = entry
+	return entry
+}
+
+// Inherits the parent's map on first write.
+func copyOnWrite[V any](own map[string]V, parent *Context, get func(*Context) map[string]V) map[string]V {
+	if own == nil {
+		if parent != nil {
+			own = maps.Clone(get(parent))
+		}
+
+		if own == nil {
+			own = make(map[string]V)
+		}
+	}
+
+	return own
+}
@@ -0,0 +1,176 @@
+package exturl
+
+import (
+	"archive/zip"
+	"bytes"
+	contextpkg "context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tliron/kutil/util"
+)
+
+func TestChildContext(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	context.Map("internal:from", "internal:to")
+	context.SetCredentials("host", "user", "password", "")
+
+	child := context.NewChild()
+	defer child.Release()
+
+	if toUrl, _ := child.GetMapping("internal:from"); toUrl != "internal:to" {
+		t.Errorf("inherited mapping: %q", toUrl)
+	}
+
+	child.Map("internal:from", "")
+	if _, ok := child.GetMapping("internal:from"); ok {
+		t.Error("child mapping was not deleted")
+	}
+	if _, ok := context.GetMapping("internal:from"); !ok {
+		t.Error("child changed parent mapping")
+	}
+
+	if credentials := child.GetCredentials("host"); (credentials == nil) || (credentials.Username != "user") {
+		t.Error("inherited credentials")
+	}
+}
+
+func TestChildContextTemporaryFiles(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	url := context.NewInternalURL("/child-context-test")
+	url.SetContent("content")
+
+	path, err := context.GetLocalPath(contextpkg.TODO(), url)
+	if err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	child := context.NewChild()
+	if childPath, err := child.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("child GetLocalPath: %s", err.Error())
+		return
+	} else if childPath != path {
+		t.Errorf("child did not reuse download: %s", childPath)
+	}
+
+	// The child is still using the file
+	context.Release()
+	if ok, _ := util.DoesFileExist(path); !ok {
+		t.Error("file deleted while child is still using it")
+	}
+
+	child.Release()
+	if ok, _ := util.DoesFileExist(path); ok {
+		t.Error("file not deleted after child was released")
+	}
+}
+
+func TestContextQuota(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	context.SetTemporaryDir(t.TempDir())
+	context.SetQuota(10)
+
+	url1 := context.NewInternalURL("/quota-test-1")
+	url1.SetContent("123456")
+	url2 := context.NewInternalURL("/quota-test-2")
+	url2.SetContent("abcdef")
+	url3 := context.NewInternalURL("/quota-test-3")
+	url3.SetContent("this is too large for the quota")
+
+	child := context.NewChild()
+	path1, err := child.GetLocalPath(contextpkg.TODO(), url1)
+	if err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+	if !strings.HasPrefix(path1, context.GetTemporaryDir()) {
+		t.Errorf("not in temporary dir: %s", path1)
+	}
+	child.Release()
+
+	// Released but kept for reuse
+	child = context.NewChild()
+	if path, _ := child.GetLocalPath(contextpkg.TODO(), url1); path != path1 {
+		t.Errorf("released file was not reused: %s", path)
+	}
+	child.Release()
+
+	// Needs the space
+	child = context.NewChild()
+	defer child.Release()
+	if _, err := child.GetLocalPath(contextpkg.TODO(), url2); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+	if ok, _ := util.DoesFileExist(path1); ok {
+		t.Error("released file was not evicted")
+	}
+
+	if _, err := child.GetLocalPath(contextpkg.TODO(), url3); !IsQuotaExceeded(err) {
+		t.Errorf("expected QuotaExceeded: %v", err)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+	context.SetOffline(true)
+
+	child := context.NewChild()
+	defer child.Release()
+
+	if _, err := testRead(child, "http://localhost/file"); !IsOffline(err) {
+		t.Errorf("network: %v", err)
+	}
+
+	if _, err := testRead(child, "git:https://github.com/tliron/exturl.git!README.md"); !IsOffline(err) {
+		t.Errorf("git: %v", err)
+	}
+
+	if _, err := testRead(child, "internal:/missing"); IsOffline(err) {
+		t.Errorf("internal: %v", err)
+	}
+}
+
+func TestReleaseWhileIndexing(t *testing.T) {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	if writer, err := zipWriter.Create("a.yaml"); err == nil {
+		writer.Write([]byte("a"))
+	} else {
+		t.Fatal(err)
+	}
+	zipWriter.Close()
+
+	context := NewContext()
+	defer context.Release()
+
+	archiveUrl := context.NewInternalURL("/release-test/archive.zip")
+	archiveUrl.SetContent(buffer.Bytes())
+
+	// Release after the archive is opened and before the index is stored
+	context.AddObserver(ObserverFunc(func(event *Event) {
+		if (event.Type == EventOpen) && (event.URL.Key() == archiveUrl.Key()) {
+			context.Release()
+		}
+	}))
+
+	if _, err := ReadString(contextpkg.TODO(), NewZipURL("a.yaml", archiveUrl)); !errors.Is(err, contextpkg.Canceled) {
+		t.Errorf("not canceled: %v", err)
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	if len(context.zipIndexes) != 0 {
+		t.Errorf("index stored after release")
+	}
+}
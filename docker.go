@@ -4,14 +4,18 @@ package exturl
 
 import (
 	contextpkg "context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	neturlpkg "net/url"
-	"path"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	namepkg "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/tliron/kutil/compression"
 )
@@ -37,7 +41,7 @@ func (self *Context) NewDockerURL(neturl *neturlpkg.URL) *DockerURL {
 
 func (self *Context) NewValidDockerURL(neturl *neturlpkg.URL) (*DockerURL, error) {
 	if (neturl.Scheme != "docker") && (neturl.Scheme != "") {
-		return nil, fmt.Errorf("not a docker URL: %s", neturl.String())
+		return nil, &UnsupportedScheme{newURLError(neturl.String(), nil, "not a docker URL: %s", neturl.String())}
 	}
 
 	// TODO
@@ -62,13 +66,7 @@ func (self *DockerURL) Format() string {
 
 // ([URL] interface)
 func (self *DockerURL) Base() URL {
-	url := *self
-	url.URL.Path = path.Dir(url.URL.Path)
-	if url.URL.Path != "/" {
-		url.URL.Path += "/"
-	}
-	// TODO: url.URL.RawPath?
-	return &url
+	return self.urlContext.NewDockerURL(getBaseNetURL(self.URL))
 }
 
 // ([URL] interface)
@@ -92,8 +90,15 @@ func (self *DockerURL) Key() string {
 
 // ([URL] interface)
 func (self *DockerURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *DockerURL) open(context contextpkg.Context) (io.ReadCloser, error) {
 	pipeReader, pipeWriter := io.Pipe()
 
+	// Errors are only returned when reading, after Open has returned
+	context = countErrorAgain(context)
+
 	go func() {
 		if err := self.WriteFirstLayer(context, pipeWriter); err == nil {
 			pipeWriter.Close()
@@ -126,17 +131,63 @@ func (self *DockerURL) WriteFirstLayer(context contextpkg.Context, writer io.Wri
 		return nil
 	} else if err == io.EOF {
 		// TODO: test that this happens
-		return NewNotFound("\"*.tar.gz\" entry not found in tarball")
+		return &NotFound{newURLError(self.Key(), nil, "\"*.tar.gz\" entry not found in tarball: %s", self.Key())}
 	} else {
 		return err
 	}
 }
 
 func (self *DockerURL) WriteTarball(context contextpkg.Context, writer io.Writer) error {
+	context, countError := countErrorOnce(context)
+	err := self.urlContext.trace(context, "exturl.WriteTarball", self, func(context contextpkg.Context) error {
+		return errorFromRegistry(self.Key(), self.writeTarball(context, writer))
+	})
+
+	if (err != nil) && countError {
+		self.urlContext.countError(self, err)
+	}
+
+	return err
+}
+
+func (self *DockerURL) writeTarball(context contextpkg.Context, writer io.Writer) error {
+	if self.urlContext.IsOffline() {
+		return &Offline{newURLError(self.Key(), nil, "offline: %s", self.Key())}
+	}
+
 	url := self.URL.Host + self.URL.Path
 	if tag, err := namepkg.NewTag(url); err == nil {
 		if image, err := remote.Image(tag, self.RemoteOptions(context)...); err == nil {
-			return tarball.Write(tag, image, writer)
+			if !self.urlContext.hasObservers() {
+				return tarball.Write(tag, image, writer)
+			}
+
+			self.urlContext.emitType(EventPullStarted, self, "", nil)
+
+			updates := make(chan v1.Update, 16)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				last := time.Now()
+				for update := range updates {
+					if now := time.Now(); (update.Error == nil) && (now.Sub(last) >= ProgressInterval) {
+						last = now
+						self.urlContext.emit(&Event{
+							Type:  EventPullProgress,
+							URL:   self,
+							Bytes: update.Complete,
+							Total: update.Total,
+						})
+					}
+				}
+			}()
+
+			err := tarball.Write(tag, image, writer, tarball.WithProgress(updates))
+			close(updates)
+			<-done
+
+			self.urlContext.emitType(EventPullCompleted, self, "", err)
+			return err
 		} else {
 			return err
 		}
@@ -153,13 +204,141 @@ func (self *DockerURL) RemoteOptions(context contextpkg.Context) []remote.Option
 	}
 
 	if credentials := self.urlContext.GetCredentials(self.URL.Host); credentials != nil {
-		authenticator := authn.FromConfig(authn.AuthConfig{
+		options = append(options, remote.WithAuth(self.authenticator()))
+	}
+
+	return options
+}
+
+func (self *DockerURL) authenticator() authn.Authenticator {
+	if credentials := self.urlContext.GetCredentials(self.URL.Host); credentials != nil {
+		return authn.FromConfig(authn.AuthConfig{
 			Username:      credentials.Username,
 			Password:      credentials.Password,
 			RegistryToken: credentials.Token,
 		})
-		options = append(options, remote.WithAuth(authenticator))
+	} else {
+		return authn.Anonymous
 	}
+}
 
-	return options
+// ([compressedRandomAccessURL] interface)
+//
+// Reads the compressed first layer via HTTP range requests on its registry
+// blob, which allows eStargz layers to be indexed (see [TarIndex]). Returns a
+// [NotImplemented] error if the registry does not support range requests.
+func (self *DockerURL) openCompressedRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	if reader, size, err := self.openCompressedRandomAccess_(context); err == nil {
+		return reader, size, nil
+	} else {
+		return nil, 0, errorFromRegistry(self.Key(), err)
+	}
+}
+
+func (self *DockerURL) openCompressedRandomAccess_(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	if self.urlContext.IsOffline() {
+		return nil, 0, &Offline{newURLError(self.Key(), nil, "offline: %s", self.Key())}
+	}
+
+	tag, err := namepkg.NewTag(self.URL.Host + self.URL.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	image, err := remote.Image(tag, self.RemoteOptions(context)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(layers) == 0 {
+		return nil, 0, &NotFound{newURLError(self.Key(), nil, "no layers in image: %s", self.Key())}
+	}
+
+	digest, err := layers[0].Digest()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err := layers[0].Size()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	httpRoundTripper := self.urlContext.GetHTTPRoundTripper(self.URL.Host)
+	if httpRoundTripper == nil {
+		httpRoundTripper = remote.DefaultTransport
+	}
+
+	httpRoundTripper, err = transport.NewWithContext(context, tag.Registry, self.authenticator(), httpRoundTripper, []string{tag.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	client := http.Client{Transport: httpRoundTripper}
+	blobUrl := fmt.Sprintf("%s://%s/v2/%s/blobs/%s", tag.Registry.Scheme(), tag.RegistryStr(), tag.RepositoryStr(), digest)
+
+	// Registries are not required to support range requests
+	if reader, err := newRangeReaderAt(context, self, func(context contextpkg.Context, header http.Header) (*http.Response, error) {
+		if request, err := http.NewRequestWithContext(context, http.MethodGet, blobUrl, nil); err == nil {
+			request.Header = header
+			return client.Do(request)
+		} else {
+			return nil, err
+		}
+	}); err == nil {
+		if reader.Size != size {
+			return nil, 0, &VerificationFailed{newURLError(self.Key(), nil, "layer blob size %d differs from manifest %d: %s", reader.Size, size, self.Key())}
+		}
+		return reader, size, nil
+	} else {
+		return nil, 0, err
+	}
+}
+
+// Returns the argument as is if it cannot be mapped
+func errorFromRegistry(url string, err error) error {
+	if (err == nil) || isMappedError(err) {
+		return err
+	}
+
+	urlError := newURLError(url, err, "%s: %s", err.Error(), url)
+
+	if namepkg.IsErrBadName(err) {
+		return &Malformed{urlError}
+	}
+
+	var transportError *transport.Error
+	if errors.As(err, &transportError) {
+		for _, diagnostic := range transportError.Errors {
+			switch diagnostic.Code {
+			case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode, transport.BlobUnknownErrorCode:
+				return &NotFound{urlError}
+			case transport.UnauthorizedErrorCode:
+				return &Unauthorized{urlError}
+			case transport.DeniedErrorCode:
+				return &Forbidden{urlError}
+			case transport.TooManyRequestsErrorCode:
+				return &RateLimited{urlError}
+			case transport.NameInvalidErrorCode, transport.TagInvalidErrorCode, transport.ManifestInvalidErrorCode:
+				return &Malformed{urlError}
+			}
+		}
+
+		switch transportError.StatusCode {
+		case http.StatusNotFound:
+			return &NotFound{urlError}
+		case http.StatusUnauthorized:
+			return &Unauthorized{urlError}
+		case http.StatusForbidden:
+			return &Forbidden{urlError}
+		case http.StatusTooManyRequests:
+			return &RateLimited{urlError}
+		}
+	}
+
+	return errorFromNet(url, err)
 }
@@ -1,33 +1,75 @@
 package exturl
 
 import (
+	contextpkg "context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
 )
 
+// The error types in this package support [errors.Is] and [errors.As], including
+// when wrapped. For example:
+//
+//	if errors.Is(err, new(exturl.NotFound)) { ... }
+//
+// They can also wrap a cause, which is accessible via [errors.Unwrap].
+
+//
+// URLError
+//
+
+// Embedded in all the error types in this package.
+type URLError struct {
+	Message string
+
+	// The offending URL. Can be empty if unknown.
+	URL string
+
+	// The underlying error. Can be nil.
+	Cause error
+}
+
+// (error interface)
+func (self *URLError) Error() string {
+	return self.Message
+}
+
+// ([errors.Unwrap] support)
+func (self *URLError) Unwrap() error {
+	return self.Cause
+}
+
+func (self *URLError) urlError() *URLError {
+	return self
+}
+
 //
 // NotFound
 //
 
 type NotFound struct {
-	Message string
+	URLError
 }
 
 func NewNotFound(message string) *NotFound {
-	return &NotFound{message}
+	return &NotFound{URLError{Message: message}}
 }
 
 func NewNotFoundf(format string, arg ...any) *NotFound {
 	return NewNotFound(fmt.Sprintf(format, arg...))
 }
 
-// (error interface)
-func (self *NotFound) Error() string {
-	return self.Message
+// ([errors.Is] support)
+func (self *NotFound) Is(target error) bool {
+	_, ok := target.(*NotFound)
+	return ok || (target == fs.ErrNotExist)
 }
 
 func IsNotFound(err error) bool {
-	_, ok := err.(*NotFound)
-	return ok
+	return errors.Is(err, new(NotFound))
 }
 
 //
@@ -35,23 +77,368 @@ func IsNotFound(err error) bool {
 //
 
 type NotImplemented struct {
-	Message string
+	URLError
 }
 
 func NewNotImplemented(message string) *NotImplemented {
-	return &NotImplemented{message}
+	return &NotImplemented{URLError{Message: message}}
 }
 
 func NewNotImplementedf(format string, arg ...any) *NotImplemented {
 	return NewNotImplemented(fmt.Sprintf(format, arg...))
 }
 
-// (error interface)
-func (self *NotImplemented) Error() string {
-	return self.Message
+// ([errors.Is] support)
+func (self *NotImplemented) Is(target error) bool {
+	_, ok := target.(*NotImplemented)
+	return ok
 }
 
 func IsNotImplemented(err error) bool {
-	_, ok := err.(*NotImplemented)
+	return errors.Is(err, new(NotImplemented))
+}
+
+//
+// Unauthorized
+//
+
+// Authentication is required or has failed, e.g. HTTP status 401.
+type Unauthorized struct {
+	URLError
+}
+
+func NewUnauthorized(message string) *Unauthorized {
+	return &Unauthorized{URLError{Message: message}}
+}
+
+func NewUnauthorizedf(format string, arg ...any) *Unauthorized {
+	return NewUnauthorized(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *Unauthorized) Is(target error) bool {
+	_, ok := target.(*Unauthorized)
+	return ok
+}
+
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, new(Unauthorized))
+}
+
+//
+// Forbidden
+//
+
+// Authenticated but not permitted, e.g. HTTP status 403.
+type Forbidden struct {
+	URLError
+}
+
+func NewForbidden(message string) *Forbidden {
+	return &Forbidden{URLError{Message: message}}
+}
+
+func NewForbiddenf(format string, arg ...any) *Forbidden {
+	return NewForbidden(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *Forbidden) Is(target error) bool {
+	_, ok := target.(*Forbidden)
+	return ok || (target == fs.ErrPermission)
+}
+
+func IsForbidden(err error) bool {
+	return errors.Is(err, new(Forbidden))
+}
+
+//
+// RateLimited
+//
+
+// E.g. HTTP status 429.
+type RateLimited struct {
+	URLError
+}
+
+func NewRateLimited(message string) *RateLimited {
+	return &RateLimited{URLError{Message: message}}
+}
+
+func NewRateLimitedf(format string, arg ...any) *RateLimited {
+	return NewRateLimited(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *RateLimited) Is(target error) bool {
+	_, ok := target.(*RateLimited)
 	return ok
 }
+
+func IsRateLimited(err error) bool {
+	return errors.Is(err, new(RateLimited))
+}
+
+//
+// Timeout
+//
+
+type Timeout struct {
+	URLError
+}
+
+func NewTimeout(message string) *Timeout {
+	return &Timeout{URLError{Message: message}}
+}
+
+func NewTimeoutf(format string, arg ...any) *Timeout {
+	return NewTimeout(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *Timeout) Is(target error) bool {
+	_, ok := target.(*Timeout)
+	return ok
+}
+
+func IsTimeout(err error) bool {
+	return errors.Is(err, new(Timeout))
+}
+
+//
+// Malformed
+//
+
+// A URL or its content could not be parsed.
+type Malformed struct {
+	URLError
+}
+
+func NewMalformed(message string) *Malformed {
+	return &Malformed{URLError{Message: message}}
+}
+
+func NewMalformedf(format string, arg ...any) *Malformed {
+	return NewMalformed(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *Malformed) Is(target error) bool {
+	_, ok := target.(*Malformed)
+	return ok
+}
+
+func IsMalformed(err error) bool {
+	return errors.Is(err, new(Malformed))
+}
+
+//
+// UnsupportedScheme
+//
+
+type UnsupportedScheme struct {
+	URLError
+}
+
+func NewUnsupportedScheme(message string) *UnsupportedScheme {
+	return &UnsupportedScheme{URLError{Message: message}}
+}
+
+func NewUnsupportedSchemef(format string, arg ...any) *UnsupportedScheme {
+	return NewUnsupportedScheme(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *UnsupportedScheme) Is(target error) bool {
+	_, ok := target.(*UnsupportedScheme)
+	return ok
+}
+
+func IsUnsupportedScheme(err error) bool {
+	return errors.Is(err, new(UnsupportedScheme))
+}
+
+//
+// TooLarge
+//
+
+// E.g. HTTP status 413.
+type TooLarge struct {
+	URLError
+}
+
+func NewTooLarge(message string) *TooLarge {
+	return &TooLarge{URLError{Message: message}}
+}
+
+func NewTooLargef(format string, arg ...any) *TooLarge {
+	return NewTooLarge(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *TooLarge) Is(target error) bool {
+	_, ok := target.(*TooLarge)
+	return ok
+}
+
+func IsTooLarge(err error) bool {
+	return errors.Is(err, new(TooLarge))
+}
+
+//
+// QuotaExceeded
+//
+
+// A kind of [TooLarge].
+type QuotaExceeded struct {
+	URLError
+	Quota int64
+}
+
+func NewQuotaExceeded(quota int64, message string) *QuotaExceeded {
+	return &QuotaExceeded{URLError{Message: message}, quota}
+}
+
+func NewQuotaExceededf(quota int64, format string, arg ...any) *QuotaExceeded {
+	return NewQuotaExceeded(quota, fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *QuotaExceeded) Is(target error) bool {
+	switch target.(type) {
+	case *QuotaExceeded, *TooLarge:
+		return true
+	default:
+		return false
+	}
+}
+
+func IsQuotaExceeded(err error) bool {
+	return errors.Is(err, new(QuotaExceeded))
+}
+
+//
+// Offline
+//
+
+// Network access was attempted while the context is offline. See
+// [Context.SetOffline].
+type Offline struct {
+	URLError
+}
+
+func NewOffline(message string) *Offline {
+	return &Offline{URLError{Message: message}}
+}
+
+func NewOfflinef(format string, arg ...any) *Offline {
+	return NewOffline(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *Offline) Is(target error) bool {
+	_, ok := target.(*Offline)
+	return ok
+}
+
+func IsOffline(err error) bool {
+	return errors.Is(err, new(Offline))
+}
+
+//
+// VerificationFailed
+//
+
+// E.g. content that differs from what was recorded in a [Lockfile].
+type VerificationFailed struct {
+	URLError
+}
+
+func NewVerificationFailed(message string) *VerificationFailed {
+	return &VerificationFailed{URLError{Message: message}}
+}
+
+func NewVerificationFailedf(format string, arg ...any) *VerificationFailed {
+	return NewVerificationFailed(fmt.Sprintf(format, arg...))
+}
+
+// ([errors.Is] support)
+func (self *VerificationFailed) Is(target error) bool {
+	_, ok := target.(*VerificationFailed)
+	return ok
+}
+
+func IsVerificationFailed(err error) bool {
+	return errors.Is(err, new(VerificationFailed))
+}
+
+// Utils
+
+func newURLError(url string, cause error, format string, arg ...any) URLError {
+	return URLError{
+		Message: fmt.Sprintf(format, arg...),
+		URL:     url,
+		Cause:   cause,
+	}
+}
+
+// Call for unexpected status codes
+func errorFromHTTPResponse(url string, response *http.Response) error {
+	urlError := newURLError(url, nil, "HTTP status %s: %s", response.Status, url)
+
+	switch response.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return &NotFound{urlError}
+	case http.StatusUnauthorized, http.StatusProxyAuthRequired:
+		return &Unauthorized{urlError}
+	case http.StatusForbidden:
+		return &Forbidden{urlError}
+	case http.StatusTooManyRequests:
+		return &RateLimited{urlError}
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return &Timeout{urlError}
+	case http.StatusRequestEntityTooLarge:
+		return &TooLarge{urlError}
+	default:
+		return &urlError
+	}
+}
+
+// True if the error is already one of our types
+func isMappedError(err error) bool {
+	var urlError interface{ urlError() *URLError }
+	return errors.As(err, &urlError)
+}
+
+// Returns the argument as is if it cannot be mapped
+func errorFromOS(url string, err error) error {
+	if (err == nil) || isMappedError(err) {
+		return err
+	}
+
+	urlError := newURLError(url, err, "%s: %s", err.Error(), url)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return &NotFound{urlError}
+	case errors.Is(err, fs.ErrPermission):
+		return &Forbidden{urlError}
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, contextpkg.DeadlineExceeded):
+		return &Timeout{urlError}
+	default:
+		return errorFromNet(url, err)
+	}
+}
+
+// Returns the argument as is if it cannot be mapped
+func errorFromNet(url string, err error) error {
+	if (err == nil) || isMappedError(err) {
+		return err
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		return &Timeout{newURLError(url, err, "%s: %s", err.Error(), url)}
+	}
+	return err
+}
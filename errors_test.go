@@ -0,0 +1,77 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/unauthorized":
+			writer.WriteHeader(http.StatusUnauthorized)
+		case "/forbidden":
+			writer.WriteHeader(http.StatusForbidden)
+		case "/rate-limited":
+			writer.WriteHeader(http.StatusTooManyRequests)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for path, is := range map[string]func(error) bool{
+		"/missing":      IsNotFound,
+		"/unauthorized": IsUnauthorized,
+		"/forbidden":    IsForbidden,
+		"/rate-limited": IsRateLimited,
+	} {
+		url, _ := context.NewURL(server.URL + path)
+		if _, err := url.Open(contextpkg.TODO()); !is(err) {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+
+	url := context.NewFileURL(t.TempDir() + PathSeparator + "missing")
+	_, err := url.Open(contextpkg.TODO())
+	if !IsNotFound(err) {
+		t.Errorf("file: %v", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file is not fs.ErrNotExist: %v", err)
+	}
+	var notFound *NotFound
+	if !errors.As(err, &notFound) || (notFound.URL != url.Key()) {
+		t.Errorf("file URL: %v", err)
+	}
+
+	if _, err := context.NewURL("unsupported:url"); !IsUnsupportedScheme(err) {
+		t.Errorf("unsupported scheme: %v", err)
+	}
+
+	if _, err := context.NewURL("zip:no-separator"); !IsMalformed(err) {
+		t.Errorf("malformed: %v", err)
+	}
+
+	RegisterInternalURL("errors/data", "not a tarball")
+	defer DeregisterInternalURL("errors/data")
+	if internalUrl, err := context.NewURL("internal:errors/data"); err == nil {
+		if _, err := NewTarballURL("a.yaml", internalUrl, "tar.rar").Open(contextpkg.TODO()); !IsNotImplemented(err) {
+			t.Errorf("unsupported tarball: %v", err)
+		}
+	} else {
+		t.Errorf("internal: %s", err.Error())
+	}
+
+	// Wrapped
+	if !IsTooLarge(errors.Join(NewQuotaExceeded(1, "quota exceeded"))) {
+		t.Error("QuotaExceeded is not TooLarge")
+	}
+}
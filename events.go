@@ -0,0 +1,247 @@
+package exturl
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Minimal interval between progress events for the same operation.
+var ProgressInterval = 100 * time.Millisecond
+
+//
+// EventType
+//
+
+type EventType int
+
+const (
+	// A URL was successfully created from a string.
+	EventResolve EventType = iota
+
+	// A URL was opened for reading. Error is set if it failed.
+	EventOpen
+
+	// A URL is being downloaded to a temporary file. Total is set if known.
+	EventDownloadStarted
+	EventDownloadProgress
+	EventDownloadCompleted
+
+	// A previously downloaded file, cloned repository, archive index, or
+	// prefetched tarball entry was found (or not found) for the URL. Message is
+	// one of the CacheKind constants, e.g. [CacheKindFile].
+	EventCacheHit
+	EventCacheMiss
+
+	// A git repository is being cloned. Progress events carry the git server's
+	// progress messages in Message.
+	EventCloneStarted
+	EventCloneProgress
+	EventCloneCompleted
+
+	// An image is being pulled from a registry. Total is calculated from the
+	// manifest.
+	EventPullStarted
+	EventPullProgress
+	EventPullCompleted
+)
+
+// ([fmt.Stringer] interface)
+func (self EventType) String() string {
+	switch self {
+	case EventResolve:
+		return "resolve"
+	case EventOpen:
+		return "open"
+	case EventDownloadStarted:
+		return "download started"
+	case EventDownloadProgress:
+		return "download progress"
+	case EventDownloadCompleted:
+		return "download completed"
+	case EventCacheHit:
+		return "cache hit"
+	case EventCacheMiss:
+		return "cache miss"
+	case EventCloneStarted:
+		return "clone started"
+	case EventCloneProgress:
+		return "clone progress"
+	case EventCloneCompleted:
+		return "clone completed"
+	case EventPullStarted:
+		return "pull started"
+	case EventPullProgress:
+		return "pull progress"
+	case EventPullCompleted:
+		return "pull completed"
+	default:
+		return "unknown"
+	}
+}
+
+//
+// Event
+//
+
+type Event struct {
+	Type EventType
+	URL  URL
+
+	// Bytes processed so far.
+	Bytes int64
+
+	// Total bytes expected, or -1 if unknown.
+	Total int64
+
+	// Additional information, depending on the event type.
+	Message string
+
+	// Set for completed events if the operation failed.
+	Error error
+}
+
+//
+// Observer
+//
+
+type Observer interface {
+	// Called synchronously, possibly from multiple goroutines at the same time.
+	// Implementations should return quickly.
+	OnEvent(event *Event)
+}
+
+//
+// ObserverFunc
+//
+
+type ObserverFunc func(event *Event)
+
+// ([Observer] interface)
+func (self ObserverFunc) OnEvent(event *Event) {
+	self(event)
+}
+
+// Adds an observer for events in this context and its children.
+//
+// Not thread-safe
+func (self *Context) AddObserver(observer Observer) {
+	self.observers = append(self.observers, observer)
+}
+
+// Events bubble up to the ancestors' observers
+func (self *Context) emit(event *Event) {
+	for context := self; context != nil; context = context.parent {
+		for _, observer := range context.observers {
+			observer.OnEvent(event)
+		}
+	}
+}
+
+func (self *Context) emitType(type_ EventType, url URL, message string, err error) {
+	self.emit(&Event{
+		Type:    type_,
+		URL:     url,
+		Total:   -1,
+		Message: message,
+		Error:   err,
+	})
+}
+
+func (self *Context) hasObservers() bool {
+	for context := self; context != nil; context = context.parent {
+		if len(context.observers) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Context) resolved(url URL, err error) (URL, error) {
+	if err == nil {
+		self.emitType(EventResolve, url, "", nil)
+	}
+	return url, err
+}
+
+//
+// progressWriter
+//
+
+type progressWriter struct {
+	writer     io.Writer
+	urlContext *Context
+	type_      EventType
+	url        URL
+	bytes      int64
+	total      int64
+	last       time.Time
+}
+
+func (self *Context) newProgressWriter(writer io.Writer, type_ EventType, url URL, total int64) *progressWriter {
+	return &progressWriter{
+		writer:     writer,
+		urlContext: self,
+		type_:      type_,
+		url:        url,
+		total:      total,
+		last:       time.Now(),
+	}
+}
+
+// ([io.Writer] interface)
+func (self *progressWriter) Write(p []byte) (int, error) {
+	n, err := self.writer.Write(p)
+	self.bytes += int64(n)
+
+	if now := time.Now(); now.Sub(self.last) >= ProgressInterval {
+		self.last = now
+		self.urlContext.emit(&Event{
+			Type:  self.type_,
+			URL:   self.url,
+			Bytes: self.bytes,
+			Total: self.total,
+		})
+	}
+
+	return n, err
+}
+
+//
+// messageWriter
+//
+
+// Emits each write as an event message, e.g. for git's progress output
+type messageWriter struct {
+	urlContext *Context
+	type_      EventType
+	url        URL
+}
+
+// ([io.Writer] interface)
+func (self *messageWriter) Write(p []byte) (int, error) {
+	if message := strings.TrimSpace(string(p)); message != "" {
+		self.urlContext.emitType(self.type_, self.url, message, nil)
+	}
+	return len(p), nil
+}
+
+// Utils
+
+// Returns -1 if unknown
+func getReaderSize(reader io.Reader) int64 {
+	switch reader_ := reader.(type) {
+	case interface{ Size() int64 }:
+		return reader_.Size()
+
+	case *os.File:
+		if stat, err := reader_.Stat(); err == nil {
+			if stat.Mode().IsRegular() {
+				return stat.Size()
+			}
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,55 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"sync"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	var types []EventType
+	var lock sync.Mutex
+	context.AddObserver(ObserverFunc(func(event *Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		types = append(types, event.Type)
+	}))
+
+	RegisterInternalURL("/events-test", "content")
+	defer DeregisterInternalURL("/events-test")
+
+	// Events should bubble up from children
+	child := context.NewChild()
+	defer child.Release()
+
+	url, err := child.NewURL("internal:/events-test")
+	if err != nil {
+		t.Errorf("NewURL: %s", err.Error())
+		return
+	}
+
+	if _, err := child.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	if _, err := child.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	expected := []EventType{EventResolve, EventCacheMiss, EventOpen, EventDownloadStarted, EventDownloadCompleted, EventCacheHit}
+	if len(types) != len(expected) {
+		t.Errorf("events: %v", types)
+		return
+	}
+	for index, type_ := range expected {
+		if types[index] != type_ {
+			t.Errorf("events: %v", types)
+			return
+		}
+	}
+}
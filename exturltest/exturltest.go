@@ -0,0 +1,229 @@
+// Conformance test suite for [exturl.URL] implementations.
+//
+// A [Factory] provides a tree containing [Files] via any URL type, and [Run]
+// checks that the URLs behave as exturl expects: Base, Relative (including ".."
+// and "."), ValidRelative, Key stability, Format, Open/Close, NotFound, and, for
+// [exturl.ListableURL] implementations, List.
+//
+// Relative is only called on a Base or on a path ending in "/", so that both
+// URL types that treat the URL as a directory and those that resolve per RFC
+// 3986 (such as "http:") conform.
+//
+// The fixture functions in this package can be used to create the tree as a
+// directory, tarball, zip, or git repository.
+package exturltest
+
+import (
+	contextpkg "context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/tliron/exturl"
+)
+
+// The tree that a [Factory] must provide.
+var Files = map[string]string{
+	"a.yaml":        "a: 1\n",
+	"dir/b.json":    "{\"b\": 2}\n",
+	"dir/sub/c.txt": "c\n",
+}
+
+// Returns the URL of "a.yaml" at the root of a tree containing [Files].
+type Factory func(t *testing.T, urlContext *exturl.Context) exturl.URL
+
+// Runs the conformance suite as subtests of "t".
+func Run(t *testing.T, factory Factory) {
+	t.Run("Open", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		for _, path := range paths() {
+			expectContent(t, url.Base().Relative(path), Files[path])
+		}
+	})
+
+	t.Run("Key", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		key := url.Key()
+		if key2 := url.Key(); key2 != key {
+			t.Errorf("key changed: %q -> %q", key, key2)
+		}
+
+		if key2 := url.Base().Relative("a.yaml").Key(); key2 != key {
+			t.Errorf("key via Base().Relative(): %q != %q", key2, key)
+		}
+
+		// Only URL types that can be parsed from their string are expected to
+		// round-trip
+		if url2, err := urlContext.NewURL(url.String()); err == nil {
+			if key2 := url2.Key(); key2 != key {
+				t.Errorf("key via NewURL(): %q != %q", key2, key)
+			}
+		}
+	})
+
+	t.Run("Relative", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		base := url.Base()
+		key := url.Key()
+
+		for _, path := range []string{"./a.yaml", "dir/../a.yaml", "dir/sub/../../a.yaml"} {
+			if key2 := base.Relative(path).Key(); key2 != key {
+				t.Errorf("Relative(%q) key: %q != %q", path, key2, key)
+			}
+		}
+
+		b := base.Relative("dir/sub/../b.json")
+		expectContent(t, b, Files["dir/b.json"])
+		expectContent(t, b.Base().Relative("sub/c.txt"), Files["dir/sub/c.txt"])
+		expectContent(t, b.Base().Relative("../a.yaml"), Files["a.yaml"])
+		expectContent(t, base.Relative("dir/").Relative("sub/./c.txt"), Files["dir/sub/c.txt"])
+	})
+
+	t.Run("Base", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		base := url.Base()
+		if key, key2 := base.Key(), base.Base().Key(); key2 != key {
+			t.Errorf("Base() of base: %q != %q", key2, key)
+		}
+
+		c := base.Relative("dir/sub/c.txt")
+		if key, key2 := base.Relative("dir/sub").Key(), c.Base().Key(); trimSlash(key2) != trimSlash(key) {
+			t.Errorf("Base() of nested: %q != %q", key2, key)
+		}
+	})
+
+	t.Run("ValidRelative", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		base := url.Base()
+
+		if b, err := base.ValidRelative(contextpkg.TODO(), "dir/b.json"); err == nil {
+			expectContent(t, b, Files["dir/b.json"])
+		} else {
+			t.Errorf("ValidRelative(): %s", err.Error())
+		}
+
+		if _, err := base.ValidRelative(contextpkg.TODO(), "missing.yaml"); !exturl.IsNotFound(err) {
+			t.Errorf("ValidRelative() of missing: %v", err)
+		}
+
+		if c, err := urlContext.NewValidURL(contextpkg.TODO(), "dir/sub/c.txt", []exturl.URL{base}); err == nil {
+			expectContent(t, c, Files["dir/sub/c.txt"])
+		} else {
+			t.Errorf("NewValidURL(): %s", err.Error())
+		}
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		for _, path := range paths() {
+			if format, expected := url.Base().Relative(path).Format(), exturl.GetFormat(path); format != expected {
+				t.Errorf("Format() of %q: %q != %q", path, format, expected)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		base := url.Base()
+		if _, ok := base.(exturl.ListableURL); !ok {
+			t.Skip("not listable")
+		}
+
+		expectList(t, base, url.Key(), base.Relative("dir").Key())
+		expectList(t, base.Relative("dir/sub/"), base.Relative("dir/sub/c.txt").Key())
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		url, urlContext := create(t, factory)
+		defer urlContext.Release()
+
+		if reader, err := url.Base().Relative("missing.yaml").Open(contextpkg.TODO()); err == nil {
+			// Some URL types can only fail when reading
+			_, err = io.ReadAll(reader)
+			reader.Close()
+			if !exturl.IsNotFound(err) {
+				t.Errorf("Open() of missing: %v", err)
+			}
+		} else if !exturl.IsNotFound(err) {
+			t.Errorf("Open() of missing: %s", err.Error())
+		}
+	})
+}
+
+func create(t *testing.T, factory Factory) (exturl.URL, *exturl.Context) {
+	t.Helper()
+	urlContext := exturl.NewContext()
+	return factory(t, urlContext), urlContext
+}
+
+func expectContent(t *testing.T, url exturl.URL, expected string) {
+	t.Helper()
+
+	if reader, err := url.Open(contextpkg.TODO()); err == nil {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Errorf("read %q: %s", url.Key(), err.Error())
+		} else if string(content) != expected {
+			t.Errorf("content of %q: %q != %q", url.Key(), content, expected)
+		}
+
+		if err := reader.Close(); err != nil {
+			t.Errorf("close %q: %s", url.Key(), err.Error())
+		}
+	} else {
+		t.Errorf("open %q: %s", url.Key(), err.Error())
+	}
+}
+
+// Expects keys in order, ignoring trailing slashes
+func expectList(t *testing.T, url exturl.URL, keys ...string) {
+	t.Helper()
+
+	if listableUrl, ok := url.(exturl.ListableURL); ok {
+		if urls, err := listableUrl.List(contextpkg.TODO()); err == nil {
+			if len(urls) != len(keys) {
+				t.Errorf("List() of %q: %d entries != %d", url.Key(), len(urls), len(keys))
+				return
+			}
+			for index, url_ := range urls {
+				if key := trimSlash(url_.Key()); key != trimSlash(keys[index]) {
+					t.Errorf("List() of %q: %q != %q", url.Key(), key, keys[index])
+				}
+			}
+		} else {
+			t.Errorf("List() of %q: %s", url.Key(), err.Error())
+		}
+	} else {
+		t.Errorf("not listable: %q", url.Key())
+	}
+}
+
+func paths() []string {
+	paths := make([]string, 0, len(Files))
+	for path := range Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func trimSlash(key string) string {
+	if (len(key) > 0) && (key[len(key)-1] == '/') {
+		return key[:len(key)-1]
+	}
+	return key
+}
@@ -0,0 +1,176 @@
+package exturltest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Writes [Files] into "dir".
+func WriteDir(t *testing.T, dir string) {
+	t.Helper()
+
+	for _, path := range paths() {
+		path_ := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path_), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path_, []byte(Files[path]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns a tarball of [Files], gzipped if "gzip_" is true.
+func Tarball(t *testing.T, gzip_ bool) []byte {
+	t.Helper()
+
+	if gzip_ {
+		return CompressedTarball(t, "tar.gz")
+	} else {
+		return CompressedTarball(t, "tar")
+	}
+}
+
+// Returns a tarball of [Files] in any of [exturl.TARBALL_ARCHIVE_FORMATS].
+//
+// There is no pure-Go bzip2 compressor, so "tar.bz2" requires the bzip2
+// binary. The test is skipped if it is not installed.
+func CompressedTarball(t *testing.T, archiveFormat string) []byte {
+	t.Helper()
+
+	var entries []TarballEntry
+	for _, path := range paths() {
+		entries = append(entries, TarballFile(path, []byte(Files[path])))
+	}
+	return TarballOf(t, archiveFormat, entries...)
+}
+
+//
+// TarballEntry
+//
+
+// An entry for [TarballOf]. Content is written only for regular files, and the
+// header's size is set from it.
+type TarballEntry struct {
+	tar.Header
+	Content []byte
+}
+
+// Returns a regular file entry.
+func TarballFile(path string, content []byte) TarballEntry {
+	return TarballEntry{
+		Header: tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path,
+			Mode:     0644,
+		},
+		Content: content,
+	}
+}
+
+// Returns a tarball of the entries, in order, in any of
+// [exturl.TARBALL_ARCHIVE_FORMATS]. See [CompressedTarball].
+func TarballOf(t *testing.T, archiveFormat string, entries ...TarballEntry) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var compressor io.WriteCloser
+	switch archiveFormat {
+	case "tar":
+	case "tar.gz":
+		compressor = gzip.NewWriter(&buffer)
+	case "tar.xz":
+		var err error
+		if compressor, err = xz.NewWriter(&buffer); err != nil {
+			t.Fatal(err)
+		}
+	case "tar.zst":
+		var err error
+		if compressor, err = zstd.NewWriter(&buffer); err != nil {
+			t.Fatal(err)
+		}
+	case "tar.lz4":
+		compressor = lz4.NewWriter(&buffer)
+	case "tar.bz2":
+		if _, err := exec.LookPath("bzip2"); err != nil {
+			t.Skip("bzip2 not installed")
+		}
+		command := exec.Command("bzip2", "-c")
+		command.Stdin = bytes.NewReader(TarballOf(t, "tar", entries...))
+		command.Stdout = &buffer
+		if err := command.Run(); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	default:
+		t.Fatalf("unsupported tarball format: %s", archiveFormat)
+	}
+
+	var tarWriter *tar.Writer
+	if compressor != nil {
+		tarWriter = tar.NewWriter(compressor)
+	} else {
+		tarWriter = tar.NewWriter(&buffer)
+	}
+
+	for _, entry := range entries {
+		header := entry.Header
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.Content))
+		}
+		if err := tarWriter.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tarWriter.Write(entry.Content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buffer.Bytes()
+}
+
+// Returns a zip of [Files].
+func Zip(t *testing.T) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+
+	for _, path := range paths() {
+		if writer, err := zipWriter.Create(path); err == nil {
+			if _, err := writer.Write([]byte(Files[path])); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,103 @@
+package exturltest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/opencontainers/go-digest"
+)
+
+// Converts a tarball (uncompressed or gzipped) to an eStargz tarball, with
+// entries split into chunks of "chunkSize" bytes (0 for the default).
+func Estargz(t *testing.T, tarball []byte, chunkSize int) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := estargz.NewWriterWithCompressor(&buffer, new(estargzCompressor))
+	writer.ChunkSize = chunkSize
+	if err := writer.AppendTar(bytes.NewReader(tarball)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+//
+// estargzCompressor
+//
+
+// Like estargz.GzipCompressor, but writes the footer without relying on the
+// exact output of compress/gzip, which changed in newer versions of Go
+type estargzCompressor struct {
+	estargz.GzipCompressor
+}
+
+// ([estargz.Compressor] interface)
+func (self *estargzCompressor) Writer(writer io.Writer) (estargz.WriteFlushCloser, error) {
+	return gzip.NewWriter(writer), nil
+}
+
+// ([estargz.Compressor] interface)
+func (self *estargzCompressor) WriteTOCAndFooter(writer io.Writer, offset int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJson, err := json.Marshal(toc)
+	if err != nil {
+		return "", err
+	}
+
+	gzipWriter := gzip.NewWriter(writer)
+	var tarWriter *tar.Writer
+	if diffHash != nil {
+		tarWriter = tar.NewWriter(io.MultiWriter(gzipWriter, diffHash))
+	} else {
+		tarWriter = tar.NewWriter(gzipWriter)
+	}
+
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     estargz.TOCTarName,
+		Size:     int64(len(tocJson)),
+	}); err != nil {
+		return "", err
+	}
+	if _, err := tarWriter.Write(tocJson); err != nil {
+		return "", err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+
+	if _, err := writer.Write(estargzFooter(offset)); err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(tocJson), nil
+}
+
+// An empty gzip member with the TOC offset in its extra field
+func estargzFooter(offset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", offset)
+	extra := []byte{'S', 'G', 0, 0}
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(subfield)))
+	extra = append(extra, subfield...)
+
+	footer := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff} // FEXTRA
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(len(extra)))
+	footer = append(footer, extra...)
+	footer = append(footer, 1, 0, 0, 0xff, 0xff)    // final empty stored block
+	footer = append(footer, 0, 0, 0, 0, 0, 0, 0, 0) // CRC-32 and size
+	return footer
+}
@@ -0,0 +1,39 @@
+//go:build !wasip1
+
+package exturltest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Creates a git repository in "dir" with [Files] committed to its default
+// branch.
+func GitRepository(t *testing.T, dir string) {
+	t.Helper()
+
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	WriteDir(t, dir)
+
+	workTree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := workTree.AddGlob("."); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := workTree.Commit("exturltest", &git.CommitOptions{
+		Author: &object.Signature{Name: "exturltest", Email: "exturltest@localhost", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,235 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Returned by [Faults] when no other error is configured.
+var ErrInjectedFault = errors.New("injected fault")
+
+//
+// Faults
+//
+
+// Faults to inject into URL opens and reads, for testing how consumers (and
+// exturl itself) handle failures. Use with [NewFaultyURL] to affect a single URL
+// or with [Context.SetFaults] to affect all URLs opened in a context.
+//
+// Zero values disable the respective fault. A Faults instance can be shared, in
+// which case opens are counted across all users.
+type Faults struct {
+	// Delay before each open. Cancelling the context stops the delay.
+	OpenLatency time.Duration
+
+	// Fail the Nth open (counting from 1) or every open after it if FailOpensAfter
+	// is true.
+	FailOpen       int
+	FailOpensAfter bool
+
+	// Returned for failed opens. Defaults to [ErrInjectedFault].
+	OpenError error
+
+	// Return io.EOF after this many bytes.
+	TruncateAfter int64
+
+	// Return ReadError after this many bytes.
+	FailReadAfter int64
+
+	// Returned for failed reads. Defaults to [ErrInjectedFault].
+	ReadError error
+
+	// Return at most one byte per read.
+	ByteByByte bool
+
+	// Delay before each read.
+	ReadLatency time.Duration
+
+	// Call Cancel once this many bytes have been read. Combine with a context
+	// created by [context.WithCancel] to test cancellation in the middle of a
+	// read.
+	CancelAfter int64
+	Cancel      contextpkg.CancelFunc
+
+	opens int
+	lock  sync.Mutex
+}
+
+// Returns the number of opens so far.
+func (self *Faults) Opens() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.opens
+}
+
+func (self *Faults) open(context contextpkg.Context, open func(context contextpkg.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	self.lock.Lock()
+	self.opens++
+	opens := self.opens
+	self.lock.Unlock()
+
+	if self.OpenLatency > 0 {
+		if err := sleep(context, self.OpenLatency); err != nil {
+			return nil, err
+		}
+	}
+
+	if (self.FailOpen > 0) && ((opens == self.FailOpen) || (self.FailOpensAfter && (opens > self.FailOpen))) {
+		if self.OpenError != nil {
+			return nil, self.OpenError
+		}
+		return nil, ErrInjectedFault
+	}
+
+	if reader, err := open(context); err == nil {
+		if self.affectsReads() {
+			return forwardSeek(&faultyReader{ReadCloser: reader, faults: self, context: context}, reader), nil
+		}
+		return reader, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *Faults) affectsReads() bool {
+	return (self.TruncateAfter > 0) || (self.FailReadAfter > 0) || self.ByteByByte || (self.ReadLatency > 0) || (self.Cancel != nil)
+}
+
+// Sets faults for all URLs opened in this context and its children. Set to nil
+// to remove.
+//
+// Opens for random access (see [RandomAccessURL]) are not affected.
+//
+// Not thread-safe
+func (self *Context) SetFaults(faults *Faults) {
+	self.faults = faults
+}
+
+// Returns nil if not set.
+//
+// Not thread-safe
+func (self *Context) GetFaults() *Faults {
+	if (self.faults == nil) && (self.parent != nil) {
+		return self.parent.GetFaults()
+	}
+	return self.faults
+}
+
+//
+// FaultyURL
+//
+
+// Wraps a URL to inject faults into its opens and reads. URLs derived from it via
+// Base, Relative, and ValidRelative are wrapped with the same faults.
+type FaultyURL struct {
+	URL
+	Faults *Faults
+}
+
+func NewFaultyURL(url URL, faults *Faults) *FaultyURL {
+	return &FaultyURL{url, faults}
+}
+
+// ([URL] interface)
+func (self *FaultyURL) Base() URL {
+	return NewFaultyURL(self.URL.Base(), self.Faults)
+}
+
+// ([URL] interface)
+func (self *FaultyURL) Relative(path string) URL {
+	return NewFaultyURL(self.URL.Relative(path), self.Faults)
+}
+
+// ([URL] interface)
+func (self *FaultyURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
+	if url, err := self.URL.ValidRelative(context, path); err == nil {
+		return NewFaultyURL(url, self.Faults), nil
+	} else {
+		return nil, err
+	}
+}
+
+// ([URL] interface)
+func (self *FaultyURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.Faults.open(context, self.URL.Open)
+}
+
+//
+// faultyReader
+//
+
+type faultyReader struct {
+	io.ReadCloser
+	faults   *Faults
+	context  contextpkg.Context
+	bytes    int64
+	canceled bool
+}
+
+// ([io.Reader] interface)
+func (self *faultyReader) Read(p []byte) (int, error) {
+	faults := self.faults
+
+	if faults.ReadLatency > 0 {
+		if err := sleep(self.context, faults.ReadLatency); err != nil {
+			return 0, err
+		}
+	}
+
+	if (faults.FailReadAfter > 0) && (self.bytes >= faults.FailReadAfter) {
+		if faults.ReadError != nil {
+			return 0, faults.ReadError
+		}
+		return 0, ErrInjectedFault
+	}
+
+	if (faults.TruncateAfter > 0) && (self.bytes >= faults.TruncateAfter) {
+		return 0, io.EOF
+	}
+
+	if len(p) > 0 {
+		// Don't read past the next fault
+		limit := int64(len(p))
+		if faults.ByteByByte {
+			limit = 1
+		}
+		for _, at := range []int64{faults.FailReadAfter, faults.TruncateAfter, faults.CancelAfter} {
+			if (at > 0) && (at > self.bytes) && (at-self.bytes < limit) {
+				limit = at - self.bytes
+			}
+		}
+		p = p[:limit]
+	}
+
+	n, err := self.ReadCloser.Read(p)
+	self.bytes += int64(n)
+
+	if (faults.Cancel != nil) && !self.canceled && (self.bytes >= faults.CancelAfter) {
+		self.canceled = true
+		faults.Cancel()
+	}
+
+	return n, err
+}
+
+func (self *faultyReader) Size() int64 {
+	return getReaderSize(self.ReadCloser)
+}
+
+// Utils
+
+func sleep(context contextpkg.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-context.Done():
+		return context.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFaultyURL(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	content := strings.Repeat("0123456789", 10)
+	faults := &Faults{FailOpen: 2}
+	url := NewFaultyURL(context.NewInternalURL("/faults"), faults)
+	url.URL.(*InternalURL).SetContent(content)
+
+	if _, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("first open: %s", err.Error())
+	}
+	if _, err := ReadBytes(contextpkg.TODO(), url); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("second open: %v", err)
+	}
+	if _, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("third open: %s", err.Error())
+	}
+
+	faults.FailOpen = 0
+	faults.TruncateAfter = 15
+	faults.ByteByByte = true
+	if b, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("truncate: %s", err.Error())
+	} else if string(b) != content[:15] {
+		t.Errorf("truncate: %q", b)
+	}
+
+	faults.TruncateAfter = 0
+	faults.FailReadAfter = 42
+	if b, err := ReadBytes(contextpkg.TODO(), url); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("fail read: %v", err)
+	} else if len(b) != 42 {
+		t.Errorf("fail read: %d bytes", len(b))
+	}
+
+	faults.FailReadAfter = 0
+	cancelContext, cancel := contextpkg.WithCancel(contextpkg.Background())
+	faults.CancelAfter = 10
+	faults.Cancel = cancel
+	if _, err := ReadBytes(cancelContext, url); !errors.Is(err, contextpkg.Canceled) {
+		t.Errorf("cancel: %v", err)
+	}
+}
+
+func TestFaultyContextDownload(t *testing.T) {
+	dir := t.TempDir()
+
+	context := NewContext()
+	defer context.Release()
+	context.SetTemporaryDir(dir)
+	context.SetFaults(&Faults{FailReadAfter: 5})
+
+	url := context.NewInternalURL("/faults")
+	url.SetContent(strings.Repeat("0123456789", 10))
+
+	if _, err := context.GetLocalPath(contextpkg.TODO(), url); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("download: %v", err)
+	}
+
+	path := filepath.Join(dir, "download")
+	if err := DownloadTo(contextpkg.TODO(), url, path); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("download to: %v", err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("not cleaned up: %d files", len(entries))
+	}
+}
@@ -48,7 +48,7 @@ func (self *Context) NewFileURL(filePath string) *FileURL {
 // an OS path separator if it doesn't already have one.
 func (self *Context) NewValidFileURL(filePath string) (*FileURL, error) {
 	if !filepath.IsAbs(filePath) {
-		return nil, fmt.Errorf("file URL path is not absolute: %s", filePath)
+		return nil, &Malformed{newURLError(filePath, nil, "file URL path is not absolute: %s", filePath)}
 	}
 
 	isDir := strings.HasSuffix(filePath, PathSeparator)
@@ -68,9 +68,9 @@ func (self *Context) NewValidFileURL(filePath string) (*FileURL, error) {
 			return nil, fmt.Errorf("file URL path does not point to a file: %s", filePath)
 		}
 	} else if os.IsNotExist(err) {
-		return nil, fmt.Errorf("file URL path not found: %s", filePath)
+		return nil, &NotFound{newURLError(filePath, err, "file URL path not found: %s", filePath)}
 	} else {
-		return nil, err
+		return nil, errorFromOS(filePath, err)
 	}
 
 	return self.NewFileURL(filePath), nil
@@ -147,10 +147,36 @@ func (self *FileURL) Key() string {
 
 // ([URL] interface)
 func (self *FileURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *FileURL) open(context contextpkg.Context) (io.ReadCloser, error) {
 	if reader, err := os.Open(self.Path); err == nil {
 		return reader, nil
 	} else {
-		return nil, err
+		return nil, errorFromOS(self.Key(), err)
+	}
+}
+
+// ([RandomAccessURL] interface)
+func (self *FileURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	return openRandomAccess(context, self)
+}
+
+// ([ListableURL] interface)
+func (self *FileURL) List(context contextpkg.Context) ([]URL, error) {
+	if dirEntries, err := os.ReadDir(self.Path); err == nil {
+		urls := make([]URL, len(dirEntries))
+		for index, dirEntry := range dirEntries {
+			path := filepath.Join(self.Path, dirEntry.Name())
+			if dirEntry.IsDir() {
+				path += PathSeparator
+			}
+			urls[index] = self.urlContext.NewFileURL(path)
+		}
+		return urls, nil
+	} else {
+		return nil, errorFromOS(self.Key(), err)
 	}
 }
 
@@ -0,0 +1,88 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"sync"
+)
+
+//
+// flightGroup
+//
+
+// Makes sure that concurrent calls for the same key share a single fetch, while
+// calls for different keys proceed in parallel.
+//
+// Each waiter can give up via its own context without affecting the other waiters.
+// The shared fetch is cancelled only when all its waiters have given up.
+type flightGroup struct {
+	flights map[string]*flight
+	lock    sync.Mutex
+}
+
+type flight struct {
+	done    chan struct{}
+	value   any
+	err     error
+	waiters int
+	cancel  contextpkg.CancelFunc
+}
+
+func (self *flightGroup) Do(context contextpkg.Context, key string, fetch func(context contextpkg.Context) (any, error)) (any, error) {
+	self.lock.Lock()
+
+	if self.flights == nil {
+		self.flights = make(map[string]*flight)
+	}
+
+	flight_, ok := self.flights[key]
+	if !ok {
+		// The fetch keeps the context's values but is not cancelled by any single waiter
+		fetchContext, cancel := contextpkg.WithCancel(contextpkg.WithoutCancel(context))
+
+		flight_ = &flight{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		self.flights[key] = flight_
+
+		go func() {
+			value, err := fetch(fetchContext)
+			cancel()
+
+			self.lock.Lock()
+			flight_.value = value
+			flight_.err = err
+			self.forget(key, flight_)
+			self.lock.Unlock()
+
+			close(flight_.done)
+		}()
+	}
+
+	flight_.waiters++
+	self.lock.Unlock()
+
+	select {
+	case <-flight_.done:
+		return flight_.value, flight_.err
+
+	case <-context.Done():
+		self.lock.Lock()
+		flight_.waiters--
+		if flight_.waiters == 0 {
+			// Nobody is waiting for the fetch anymore
+			flight_.cancel()
+			self.forget(key, flight_)
+		}
+		self.lock.Unlock()
+
+		return nil, context.Err()
+	}
+}
+
+// Call while holding the lock
+func (self *flightGroup) forget(key string, flight_ *flight) {
+	if self.flights[key] == flight_ {
+		delete(self.flights, key)
+	}
+}
@@ -0,0 +1,91 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var group flightGroup
+	var fetches atomic.Int32
+	release := make(chan struct{})
+
+	fetch := func(context contextpkg.Context) (any, error) {
+		fetches.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	var wait sync.WaitGroup
+	for range 5 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if value, err := group.Do(contextpkg.Background(), "key", fetch); err != nil {
+				t.Errorf("Do: %s", err.Error())
+			} else if value != "value" {
+				t.Errorf("Do: %v", value)
+			}
+		}()
+	}
+
+	waitForWaiters(&group, "key", 5)
+
+	// A waiter that gives up should not affect the others
+	cancelledContext, cancel := contextpkg.WithCancel(contextpkg.Background())
+	cancel()
+	if _, err := group.Do(cancelledContext, "key", fetch); err != contextpkg.Canceled {
+		t.Errorf("cancelled Do: %v", err)
+	}
+
+	close(release)
+	wait.Wait()
+
+	if count := fetches.Load(); count != 1 {
+		t.Errorf("fetches: %d", count)
+	}
+}
+
+func TestFlightGroupAbandoned(t *testing.T) {
+	var group flightGroup
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	context, cancel := contextpkg.WithCancel(contextpkg.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	group.Do(context, "key", func(context contextpkg.Context) (any, error) {
+		close(started)
+		<-context.Done()
+		close(cancelled)
+		return nil, context.Err()
+	})
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("fetch was not cancelled after all waiters gave up")
+	}
+}
+
+// Waits until the flight for the key has at least this many waiters.
+func waitForWaiters(group *flightGroup, key string, waiters int) {
+	for {
+		group.lock.Lock()
+		flight_ := group.flights[key]
+		ok := (flight_ != nil) && (flight_.waiters >= waiters)
+		group.lock.Unlock()
+
+		if ok {
+			return
+		}
+		runtime.Gosched()
+	}
+}
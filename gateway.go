@@ -0,0 +1,308 @@
+package exturl
+
+import (
+	"bytes"
+	contextpkg "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	neturlpkg "net/url"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum size of content that [Gateway] will buffer in memory in order to
+// support ETags and ranges for URLs that do not support seeking.
+var GatewayMaxBufferSize int64 = 32 * 1024 * 1024
+
+//
+// Gateway
+//
+
+// An [http.Handler] that serves the content of exturl URLs.
+//
+// Request paths are mapped to URLs through mounts (see [Gateway.Mount]). Content
+// is served with a Content-Type derived from [URL.Format], an ETag, and support
+// for ranges and conditional requests. Paths ending in "/" are served as HTML
+// directory listings if the URL is a [ListableURL].
+type Gateway struct {
+	urlContext *Context
+	mounts     map[string]URL
+	lock       sync.RWMutex
+}
+
+func NewGateway(urlContext *Context) *Gateway {
+	return &Gateway{
+		urlContext: urlContext,
+		mounts:     make(map[string]URL),
+	}
+}
+
+// Maps request paths starting with "prefix" to paths relative to "url". The URL
+// should be a "base directory", e.g.:
+//
+//	gateway.Mount("/vendor/", "tar:https://site.org/vendor.tar.gz!/")
+//	gateway.Mount("/repo/", "git:https://github.com/user/repo.git!/")
+//
+// If several mounts match a request path, the longest prefix wins.
+func (self *Gateway) Mount(prefix string, url string) error {
+	if url_, err := self.urlContext.NewURL(url); err == nil {
+		self.MountURL(prefix, url_)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// As [Gateway.Mount] but with an existing URL.
+func (self *Gateway) MountURL(prefix string, url URL) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.mounts[prefix] = url
+}
+
+// ([http.Handler] interface)
+func (self *Gateway) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if (request.Method != http.MethodGet) && (request.Method != http.MethodHead) {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	url, isDir, ok := self.resolve(request.URL.Path)
+	if !ok {
+		if _, _, ok := self.resolve(request.URL.Path + "/"); ok {
+			// Mount without the trailing slash
+			http.Redirect(writer, request, request.URL.Path+"/", http.StatusMovedPermanently)
+		} else {
+			http.NotFound(writer, request)
+		}
+		return
+	}
+
+	if isDir {
+		self.serveDir(writer, request, url)
+	} else {
+		self.serveContent(writer, request, url)
+	}
+}
+
+func (self *Gateway) resolve(path string) (URL, bool, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	var found string
+	for prefix := range self.mounts {
+		if (len(prefix) > len(found)) && strings.HasPrefix(path, prefix) {
+			found = prefix
+		}
+	}
+
+	if found == "" {
+		return nil, false, false
+	}
+
+	url := self.mounts[found]
+	rest := strings.TrimPrefix(path, found)
+	isDir := (rest == "") || strings.HasSuffix(rest, "/")
+
+	// Cleaning prevents ".." from escaping the mount
+	if rest = strings.TrimPrefix(pathpkg.Clean("/"+rest), "/"); rest != "" {
+		url = url.Relative(rest)
+	}
+
+	return url, isDir, true
+}
+
+func (self *Gateway) serveContent(writer http.ResponseWriter, request *http.Request, url URL) {
+	context := request.Context()
+
+	reader, err := url.Open(context)
+	if err != nil {
+		writeGatewayError(writer, err)
+		return
+	}
+	defer reader.Close()
+
+	header := writer.Header()
+	if contentType := GetContentType(url.Format()); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	commit, _ := getCommit(context, url)
+	if commit != "" {
+		header.Set("ETag", fmt.Sprintf("%q", commit+":"+url.Key()))
+	}
+
+	if file, ok := reader.(*os.File); ok {
+		if stat, err := file.Stat(); err == nil {
+			if commit == "" {
+				header.Set("ETag", fmt.Sprintf("\"%x-%x\"", stat.ModTime().UnixNano(), stat.Size()))
+			}
+			http.ServeContent(writer, request, "", stat.ModTime(), file)
+			return
+		}
+	}
+
+	if readSeeker, ok := reader.(io.ReadSeeker); ok {
+		if (commit == "") && (getReaderSize(reader) <= GatewayMaxBufferSize) {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, readSeeker); err != nil {
+				writeGatewayError(writer, err)
+				return
+			}
+			if _, err := readSeeker.Seek(0, io.SeekStart); err != nil {
+				writeGatewayError(writer, err)
+				return
+			}
+			header.Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(hash.Sum(nil))))
+		}
+
+		http.ServeContent(writer, request, "", time.Time{}, readSeeker)
+		return
+	}
+
+	if size := getReaderSize(reader); (size >= 0) && (size > GatewayMaxBufferSize) {
+		// Too big to buffer, so we can only stream
+		header.Set("Content-Length", fmt.Sprintf("%d", size))
+		if request.Method != http.MethodHead {
+			io.Copy(writer, reader)
+		}
+		return
+	}
+
+	content, err := io.ReadAll(io.LimitReader(reader, GatewayMaxBufferSize+1))
+	if err != nil {
+		writeGatewayError(writer, err)
+		return
+	}
+
+	if int64(len(content)) > GatewayMaxBufferSize {
+		// Size was unknown and turned out too big to buffer
+		writer.WriteHeader(http.StatusOK)
+		if request.Method != http.MethodHead {
+			writer.Write(content)
+			io.Copy(writer, reader)
+		}
+		return
+	}
+
+	if commit == "" {
+		hash := sha256.Sum256(content)
+		header.Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(hash[:])))
+	}
+
+	http.ServeContent(writer, request, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (self *Gateway) serveDir(writer http.ResponseWriter, request *http.Request, url URL) {
+	listableUrl, ok := url.(ListableURL)
+	if !ok {
+		http.Error(writer, "directory listing not supported", http.StatusNotFound)
+		return
+	}
+
+	urls, err := listableUrl.List(request.Context())
+	if err != nil {
+		writeGatewayError(writer, err)
+		return
+	}
+
+	names := make([]string, len(urls))
+	for index, url_ := range urls {
+		names[index] = getLastPathElement(url_.Key())
+	}
+	sort.Strings(names)
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if request.Method == http.MethodHead {
+		return
+	}
+
+	fmt.Fprintf(writer, "<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<ul>\n", html.EscapeString(request.URL.Path))
+	for _, name := range names {
+		// Like net/http's dirList, so that names are never parsed as schemes,
+		// queries, or fragments
+		href := (&neturlpkg.URL{Path: name}).String()
+		fmt.Fprintf(writer, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	fmt.Fprint(writer, "</ul>\n</body>\n</html>\n")
+}
+
+// Returns an empty string if unknown.
+func GetContentType(format string) string {
+	switch format {
+	case "":
+		return ""
+	case "yaml":
+		return "application/yaml"
+	case "json":
+		return "application/json"
+	case "xml":
+		return "application/xml"
+	case "txt":
+		return "text/plain; charset=utf-8"
+	case "tar":
+		return "application/x-tar"
+	case "tar.gz":
+		return "application/gzip"
+	case "tar.xz":
+		return "application/x-xz"
+	case "tar.zst":
+		return "application/zstd"
+	case "tar.bz2":
+		return "application/x-bzip2"
+	case "tar.lz4":
+		return "application/x-lz4"
+	default:
+		return mime.TypeByExtension("." + format)
+	}
+}
+
+func writeGatewayError(writer http.ResponseWriter, err error) {
+	var status int
+	switch {
+	case IsNotFound(err):
+		status = http.StatusNotFound
+	case IsForbidden(err):
+		status = http.StatusForbidden
+	case IsMalformed(err), IsUnsupportedScheme(err):
+		status = http.StatusBadRequest
+	case IsTimeout(err), errors.Is(err, contextpkg.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case IsOffline(err), IsRateLimited(err):
+		status = http.StatusServiceUnavailable
+	case IsNotImplemented(err):
+		status = http.StatusNotImplemented
+	default:
+		status = http.StatusBadGateway
+	}
+
+	// The error may contain local paths and upstream URLs, so we only log it
+	log.Warningf("gateway: %s", err.Error())
+	http.Error(writer, http.StatusText(status), status)
+}
+
+// Keeps the trailing slash of directories
+func getLastPathElement(key string) string {
+	key_ := strings.TrimSuffix(key, "/")
+	name := key_[strings.LastIndexAny(key_, "/!")+1:]
+	if key_ != key {
+		name += "/"
+	}
+	return name
+}
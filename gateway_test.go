@@ -0,0 +1,179 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestGateway(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := writeFile(t, "tree.tar", exturltest.Tarball(t, false))
+	url := newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!a.yaml")
+
+	gateway := exturl.NewGateway(urlContext)
+	gateway.MountURL("/vendor/", url.Base())
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	response, body := gatewayGet(t, server.URL+"/vendor/dir/b.json", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status: %d", response.StatusCode)
+	}
+	if body != exturltest.Files["dir/b.json"] {
+		t.Errorf("content: %q", body)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type: %s", contentType)
+	}
+
+	etag := response.Header.Get("ETag")
+	if etag == "" {
+		t.Errorf("no ETag")
+	} else if response, _ := gatewayGet(t, server.URL+"/vendor/dir/b.json", map[string]string{"If-None-Match": etag}); response.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match status: %d", response.StatusCode)
+	}
+
+	if response, body := gatewayGet(t, server.URL+"/vendor/a.yaml", map[string]string{"Range": "bytes=0-1"}); response.StatusCode != http.StatusPartialContent {
+		t.Errorf("Range status: %d", response.StatusCode)
+	} else if body != "a:" {
+		t.Errorf("Range content: %q", body)
+	}
+
+	if response, body := gatewayGet(t, server.URL+"/vendor/dir/", nil); response.StatusCode != http.StatusOK {
+		t.Errorf("listing status: %d", response.StatusCode)
+	} else if !strings.Contains(body, `href="b.json"`) || !strings.Contains(body, `href="sub/"`) {
+		t.Errorf("listing: %s", body)
+	}
+
+	if response, body := gatewayGet(t, server.URL+"/vendor/../vendor/missing.yaml", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("missing status: %d", response.StatusCode)
+	} else if strings.Contains(body, path) {
+		t.Errorf("missing leaks error: %s", body)
+	}
+
+	if response, _ := gatewayGet(t, server.URL+"/other/a.yaml", nil); response.StatusCode != http.StatusNotFound {
+		t.Errorf("unmounted status: %d", response.StatusCode)
+	}
+}
+
+func TestGatewayListingNames(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "javascript:alert(1)"), nil)
+	writeFile(t, filepath.Join(dir, "a?b#c%d"), nil)
+
+	gateway := exturl.NewGateway(urlContext)
+	gateway.MountURL("/files/", urlContext.NewFileURL(dir+"/"))
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	if response, body := gatewayGet(t, server.URL+"/files/", nil); response.StatusCode != http.StatusOK {
+		t.Errorf("listing status: %d", response.StatusCode)
+	} else if !strings.Contains(body, `href="./javascript:alert%281%29"`) || !strings.Contains(body, `href="a%3Fb%23c%25d"`) {
+		t.Errorf("listing: %s", body)
+	}
+}
+
+func TestGatewayRangeWithMetrics(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	metrics := new(gatewayMetrics)
+	urlContext.SetMetrics(metrics)
+
+	// Too big to buffer, so ranges require Seek
+	maxBufferSize := exturl.GatewayMaxBufferSize
+	exturl.GatewayMaxBufferSize = 16
+	defer func() {
+		exturl.GatewayMaxBufferSize = maxBufferSize
+	}()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "big.txt"), []byte(strings.Repeat("0123456789", 100)))
+
+	gateway := exturl.NewGateway(urlContext)
+	gateway.MountURL("/files/", urlContext.NewFileURL(dir+"/"))
+
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	if response, body := gatewayGet(t, server.URL+"/files/big.txt", map[string]string{"Range": "bytes=12-15"}); response.StatusCode != http.StatusPartialContent {
+		t.Errorf("Range status: %d", response.StatusCode)
+	} else if body != "2345" {
+		t.Errorf("Range content: %q", body)
+	}
+
+	if bytesRead := metrics.bytesRead.Load(); bytesRead == 0 {
+		t.Errorf("no bytes read")
+	}
+}
+
+//
+// gatewayMetrics
+//
+
+type gatewayMetrics struct {
+	bytesRead atomic.Int64
+}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) AddBytesRead(scheme string, bytes int64) {
+	self.bytesRead.Add(bytes)
+}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) ObserveOpenLatency(scheme string, duration time.Duration) {}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) AddCacheHit(kind string) {}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) AddCacheMiss(kind string) {}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) ObserveCloneDuration(duration time.Duration) {}
+
+// ([exturl.Metrics] interface)
+func (self *gatewayMetrics) AddError(scheme string, errorType string) {}
+
+func gatewayGet(t *testing.T, url string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("request: %s", err.Error())
+	}
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("get %s: %s", url, err.Error())
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("read %s: %s", url, err.Error())
+	}
+
+	return response, string(body)
+}
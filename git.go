@@ -4,13 +4,16 @@ package exturl
 
 import (
 	contextpkg "context"
+	"errors"
 	"fmt"
 	"io"
+	nethttp "net/http"
 	neturlpkg "net/url"
 	"os"
 	pathpkg "path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -29,7 +32,6 @@ type GitURL struct {
 	Username      string
 	Password      string
 
-	clonePath  string
 	urlContext *Context
 }
 
@@ -61,14 +63,14 @@ func (self *Context) NewGitURL(path string, repositoryUrl string) *GitURL {
 	return &gitUrl
 }
 
-func (self *Context) NewValidGitURL(path string, repositoryUrl string) (*GitURL, error) {
+func (self *Context) NewValidGitURL(context contextpkg.Context, path string, repositoryUrl string) (*GitURL, error) {
 	gitUrl := self.NewGitURL(path, repositoryUrl)
-	if _, err := gitUrl.OpenRepository(); err == nil {
-		path := filepath.Join(gitUrl.clonePath, gitUrl.Path)
+	if _, clonePath, err := gitUrl.openClone(context); err == nil {
+		path := filepath.Join(clonePath, gitUrl.Path)
 		if _, err := os.Stat(path); err == nil {
 			return gitUrl, nil
 		} else {
-			return nil, err
+			return nil, errorFromOS(gitUrl.Key(), err)
 		}
 	} else {
 		return nil, err
@@ -83,9 +85,9 @@ func (self *Context) ParseGitURL(url string) (*GitURL, error) {
 	}
 }
 
-func (self *Context) ParseValidGitURL(url string) (*GitURL, error) {
+func (self *Context) ParseValidGitURL(context contextpkg.Context, url string) (*GitURL, error) {
 	if repositoryUrl, path, err := parseGitURL(url); err == nil {
-		return self.NewValidGitURL(path, repositoryUrl)
+		return self.NewValidGitURL(context, path, repositoryUrl)
 	} else {
 		return nil, err
 	}
@@ -103,15 +105,14 @@ func (self *GitURL) Format() string {
 
 // ([URL] interface)
 func (self *GitURL) Base() URL {
-	path := pathpkg.Dir(self.Path)
-	if path != "/" {
-		path += "/"
-	}
+	path := getArchiveBasePath(self.Path)
 
 	return &GitURL{
 		Path:          path,
 		RepositoryURL: self.RepositoryURL,
-		clonePath:     self.clonePath,
+		Reference:     self.Reference,
+		Username:      self.Username,
+		Password:      self.Password,
 		urlContext:    self.urlContext,
 	}
 }
@@ -121,7 +122,9 @@ func (self *GitURL) Relative(path string) URL {
 	return &GitURL{
 		Path:          pathpkg.Join(self.Path, path),
 		RepositoryURL: self.RepositoryURL,
-		clonePath:     self.clonePath,
+		Reference:     self.Reference,
+		Username:      self.Username,
+		Password:      self.Password,
 		urlContext:    self.urlContext,
 	}
 }
@@ -129,12 +132,12 @@ func (self *GitURL) Relative(path string) URL {
 // ([URL] interface)
 func (self *GitURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
 	gitUrl := self.Relative(path).(*GitURL)
-	if _, err := gitUrl.OpenRepository(); err == nil {
-		path_ := filepath.Join(gitUrl.clonePath, gitUrl.Path)
+	if _, clonePath, err := gitUrl.openClone(context); err == nil {
+		path_ := filepath.Join(clonePath, gitUrl.Path)
 		if _, err := os.Stat(path_); err == nil {
 			return gitUrl, nil
 		} else {
-			return nil, err
+			return nil, errorFromOS(gitUrl.Key(), err)
 		}
 	} else {
 		return nil, err
@@ -143,17 +146,52 @@ func (self *GitURL) ValidRelative(context contextpkg.Context, path string) (URL,
 
 // ([URL] interface)
 func (self *GitURL) Key() string {
-	return fmt.Sprintf("git:%s!/%s", self.RepositoryURL, self.Path)
+	return fmt.Sprintf("git:%s!/%s", self.RepositoryURL, escapeArchivePath(self.Path))
 }
 
 // ([URL] interface)
 func (self *GitURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
-	if _, err := self.OpenRepository(); err == nil {
-		path := filepath.Join(self.clonePath, self.Path)
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *GitURL) open(context contextpkg.Context) (io.ReadCloser, error) {
+	if _, clonePath, err := self.openClone(context); err == nil {
+		path := filepath.Join(clonePath, self.Path)
 		if reader, err := os.Open(path); err == nil {
 			return reader, nil
 		} else {
-			return nil, err
+			return nil, errorFromOS(self.Key(), err)
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// ([RandomAccessURL] interface)
+func (self *GitURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	return openRandomAccess(context, self)
+}
+
+// ([ListableURL] interface)
+func (self *GitURL) List(context contextpkg.Context) ([]URL, error) {
+	if _, clonePath, err := self.openClone(context); err == nil {
+		if dirEntries, err := os.ReadDir(filepath.Join(clonePath, self.Path)); err == nil {
+			var urls []URL
+			for _, dirEntry := range dirEntries {
+				name := dirEntry.Name()
+				if (name == ".git") && (strings.Trim(self.Path, "/") == "") {
+					continue
+				}
+
+				gitUrl := self.Relative(name).(*GitURL)
+				if dirEntry.IsDir() {
+					gitUrl.Path += "/"
+				}
+				urls = append(urls, gitUrl)
+			}
+			return urls, nil
+		} else {
+			return nil, errorFromOS(self.Key(), err)
 		}
 	} else {
 		return nil, err
@@ -165,72 +203,188 @@ func (self *GitURL) Context() *Context {
 	return self.urlContext
 }
 
-func (self *GitURL) OpenRepository() (*git.Repository, error) {
-	if self.clonePath != "" {
-		return self.openRepository(false)
+// Clones the repository if it hasn't yet been cloned for the exturl Context (or
+// into its cache dir, see [Context.SetCacheDir]).
+//
+// Concurrent calls for the same repository will share a single clone, while calls
+// for different repositories proceed in parallel. Cancelling the context will stop
+// this call from waiting, but the shared clone will only be cancelled if no other
+// calls are waiting for it.
+func (self *GitURL) OpenRepository(context contextpkg.Context) (*git.Repository, error) {
+	repository, _, err := self.openClone(context)
+	return repository, err
+}
+
+// Like [GitURL.OpenRepository], but also returns the path of the clone.
+func (self *GitURL) openClone(context contextpkg.Context) (*git.Repository, string, error) {
+	var repository *git.Repository
+	var clonePath string
+	context, countError := countErrorOnce(context)
+	err := self.urlContext.trace(context, "exturl.OpenRepository", self, func(context contextpkg.Context) error {
+		var err error
+		repository, clonePath, err = self.openRepositoryOrClone(context)
+		return errorFromGit(self.Key(), err)
+	})
+
+	if (err != nil) && countError {
+		self.urlContext.countError(self, err)
+	}
+
+	return repository, clonePath, err
+}
+
+// Returns the hash of the checked-out commit, cloning the repository if necessary.
+func (self *GitURL) Commit(context contextpkg.Context) (string, error) {
+	if repository, err := self.OpenRepository(context); err == nil {
+		if head, err := repository.Head(); err == nil {
+			return head.Hash().String(), nil
+		} else {
+			return "", errorFromGit(self.Key(), err)
+		}
 	} else {
-		key := self.Key()
+		return "", err
+	}
+}
 
-		// Note: this will lock for the entire clone duration!
-		self.urlContext.lock.Lock()
-		defer self.urlContext.lock.Unlock()
+// Returns the repository and the path of its clone. The path is not stored in
+// the URL, because the URL might be used concurrently.
+func (self *GitURL) openRepositoryOrClone(context contextpkg.Context) (*git.Repository, string, error) {
+	key := self.repositoryKey()
+
+	var clonePath string
+	if entry, err := self.urlContext.getTemporaryEntry(key, true); err == nil {
+		if entry != nil {
+			self.urlContext.cacheLookup(self, true, CacheKindRepository)
+			clonePath = entry.path
+		} else if clonePath_, err := self.urlContext.clones.Do(context, key, func(context contextpkg.Context) (any, error) {
+			// Another clone might have completed in the meantime
+			if entry, err := self.urlContext.getTemporaryEntry(key, true); err == nil {
+				if entry != nil {
+					return entry.path, nil
+				}
+			} else {
+				return nil, err
+			}
 
-		if self.urlContext.dirs != nil {
-			// Already cloned?
-			if clonePath, ok := self.urlContext.dirs[key]; ok {
-				self.clonePath = clonePath
-				return self.openRepository(false)
+			if clonePath, err := self.urlContext.getCached(key); err == nil {
+				if clonePath != "" {
+					self.urlContext.cacheLookup(self, true, CacheKindRepository)
+					return clonePath, nil
+				}
+			} else {
+				return nil, err
 			}
-		}
 
-		if clonePath, err := os.MkdirTemp("", GetTemporaryPathPattern(key)); err == nil {
-			if self.urlContext.dirs == nil {
-				self.urlContext.dirs = make(map[string]string)
+			self.urlContext.cacheLookup(self, false, CacheKindRepository)
+			self.urlContext.emitType(EventCloneStarted, self, self.RepositoryURL, nil)
+			start := time.Now()
+			clonePath, err := self.clone(context, key)
+			if metrics := self.urlContext.GetMetrics(); metrics != nil {
+				metrics.ObserveCloneDuration(time.Since(start))
 			}
-			self.urlContext.dirs[key] = clonePath
-
-			// Clone
-			fmt.Println(self.RepositoryURL)
-			if repository, err := git.PlainClone(clonePath, false, &git.CloneOptions{
-				URL:   self.RepositoryURL,
-				Auth:  self.getAuth(),
-				Depth: 1,
-				Tags:  git.NoTags,
-			}); err == nil {
-				if reference, err := self.findReference(repository); err == nil {
-					if reference != nil {
-						// Checkout
-						if workTree, err := repository.Worktree(); err == nil {
-							if err := workTree.Checkout(&git.CheckoutOptions{
-								Branch: reference.Name(),
-							}); err != nil {
-								os.RemoveAll(clonePath)
-								return nil, err
-							}
-						} else {
-							os.RemoveAll(clonePath)
-							return nil, err
+			self.urlContext.emitType(EventCloneCompleted, self, self.RepositoryURL, err)
+			return clonePath, err
+		}); err == nil {
+			clonePath = clonePath_.(string)
+		} else {
+			return nil, "", err
+		}
+	} else {
+		return nil, "", err
+	}
+
+	if repository, err := self.openRepository(clonePath, false); err == nil {
+		return repository, clonePath, nil
+	} else {
+		return nil, "", err
+	}
+}
+
+func (self *GitURL) clone(context contextpkg.Context, key string) (string, error) {
+	if self.urlContext.IsOffline() && !isLocalRepositoryURL(self.RepositoryURL) {
+		return "", &Offline{newURLError(self.Key(), nil, "offline: %s", self.Key())}
+	}
+
+	dir := self.urlContext.GetCacheDir()
+	if dir != "" {
+		var err error
+		if dir, err = self.urlContext.getCacheDir(); err != nil {
+			return "", err
+		}
+	} else {
+		dir = self.urlContext.getCleanTemporaryDir()
+	}
+
+	if clonePath, err := os.MkdirTemp(dir, GetTemporaryPathPattern(key)); err == nil {
+		if err := writePidFile(clonePath); err != nil {
+			DeleteTemporaryDir(clonePath)
+			return "", err
+		}
+
+		cloneOptions := git.CloneOptions{
+			URL:   self.RepositoryURL,
+			Auth:  self.getAuth(),
+			Depth: 1,
+			Tags:  git.NoTags,
+		}
+
+		if self.urlContext.hasObservers() {
+			cloneOptions.Progress = &messageWriter{self.urlContext, EventCloneProgress, self}
+		}
+
+		if repository, err := git.PlainCloneContext(context, clonePath, false, &cloneOptions); err == nil {
+			if reference, err := self.findReference(repository); err == nil {
+				if reference != nil {
+					// Checkout
+					if workTree, err := repository.Worktree(); err == nil {
+						if err := workTree.Checkout(&git.CheckoutOptions{
+							Branch: reference.Name(),
+						}); err != nil {
+							DeleteTemporaryDir(clonePath)
+							return "", err
 						}
+					} else {
+						DeleteTemporaryDir(clonePath)
+						return "", err
 					}
-				} else {
-					os.RemoveAll(clonePath)
-					return nil, err
 				}
-
-				self.clonePath = clonePath
-				return repository, nil
 			} else {
-				os.RemoveAll(clonePath)
-				return nil, err
+				DeleteTemporaryDir(clonePath)
+				return "", err
+			}
+
+			if self.urlContext.GetCacheDir() != "" {
+				return moveToCache(clonePath, dir, key, true)
 			}
+
+			var size int64
+			quota := self.urlContext.getQuota()
+			if quota != nil {
+				// We can only measure the clone after the fact
+				if size, err = getDirSize(clonePath); err == nil {
+					if err := quota.reserve(size); err != nil {
+						DeleteTemporaryDir(clonePath)
+						return "", err
+					}
+				} else {
+					DeleteTemporaryDir(clonePath)
+					return "", err
+				}
+			}
+
+			entry := self.urlContext.addTemporaryEntry(key, newTemporaryEntry(key, clonePath, true, size, quota))
+			return entry.path, nil
 		} else {
-			return nil, err
+			DeleteTemporaryDir(clonePath)
+			return "", err
 		}
+	} else {
+		return "", err
 	}
 }
 
-func (self *GitURL) openRepository(pull bool) (*git.Repository, error) {
-	if repository, err := git.PlainOpen(self.clonePath); err == nil {
+func (self *GitURL) openRepository(clonePath string, pull bool) (*git.Repository, error) {
+	if repository, err := git.PlainOpen(clonePath); err == nil {
 		if pull {
 			if err := self.pullRepository(repository); err != nil {
 				return nil, err
@@ -270,7 +424,7 @@ func (self *GitURL) findReference(repository *git.Repository) (*plumbing.Referen
 						return reference, nil
 					}
 				} else if err == io.EOF {
-					return nil, NewNotFoundf("reference %q not found in git repository: %s", self.Reference, self.RepositoryURL)
+					return nil, &NotFound{newURLError(self.Key(), nil, "reference %q not found in git repository: %s", self.Reference, self.RepositoryURL)}
 				} else {
 					return nil, err
 				}
@@ -295,14 +449,55 @@ func (self *GitURL) getAuth() transport.AuthMethod {
 	}
 }
 
+// Returns the argument as is if it cannot be mapped
+func errorFromGit(url string, err error) error {
+	if (err == nil) || isMappedError(err) {
+		return err
+	}
+
+	urlError := newURLError(url, err, "%s: %s", err.Error(), url)
+
+	var httpErr *http.Err
+	switch {
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, transport.ErrEmptyRemoteRepository),
+		errors.Is(err, plumbing.ErrReferenceNotFound), errors.Is(err, git.ErrRepositoryNotExists):
+		return &NotFound{urlError}
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrInvalidAuthMethod):
+		return &Unauthorized{urlError}
+	case errors.Is(err, transport.ErrAuthorizationFailed):
+		return &Forbidden{urlError}
+	case errors.As(err, &httpErr) && (httpErr.Response != nil):
+		switch httpErr.Response.StatusCode {
+		case nethttp.StatusTooManyRequests:
+			return &RateLimited{urlError}
+		case nethttp.StatusRequestTimeout, nethttp.StatusGatewayTimeout:
+			return &Timeout{urlError}
+		}
+	}
+
+	return errorFromOS(url, err)
+}
+
+func isLocalRepositoryURL(repositoryUrl string) bool {
+	if endpoint, err := transport.NewEndpoint(repositoryUrl); err == nil {
+		return endpoint.Protocol == "file"
+	}
+	return false
+}
+
+// Clones are shared by all URLs referring to the same repository reference
+func (self *GitURL) repositoryKey() string {
+	return fmt.Sprintf("git:%s#%s", self.RepositoryURL, self.Reference)
+}
+
 func parseGitURL(url string) (string, string, error) {
 	if strings.HasPrefix(url, "git:") {
-		if split := strings.Split(url[4:], "!"); len(split) == 2 {
-			return split[0], split[1], nil
+		if repositoryUrl, path, ok := splitArchiveURL(url[4:]); ok {
+			return repositoryUrl, path, nil
 		} else {
-			return "", "", fmt.Errorf("malformed \"git:\" URL: %s", url)
+			return "", "", &Malformed{newURLError(url, nil, "malformed \"git:\" URL: %s", url)}
 		}
 	} else {
-		return "", "", fmt.Errorf("not a \"git:\" URL: %s", url)
+		return "", "", &UnsupportedScheme{newURLError(url, nil, "not a \"git:\" URL: %s", url)}
 	}
 }
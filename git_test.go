@@ -0,0 +1,37 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	contextpkg "context"
+	"sync"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestGitConcurrentOpen(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	dir := t.TempDir()
+	exturltest.GitRepository(t, dir)
+
+	// The same URL instance, as this is the case the shared clone is meant for
+	url := newURL(t, urlContext, "git:"+urlContext.NewFileURL(dir).String()+"!a.yaml")
+
+	var waitGroup sync.WaitGroup
+	for range 8 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if content, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+				t.Errorf("read: %s", err.Error())
+			} else if content != exturltest.Files["a.yaml"] {
+				t.Errorf("content: %q", content)
+			}
+		}()
+	}
+	waitGroup.Wait()
+}
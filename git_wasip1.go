@@ -2,6 +2,10 @@
 
 package exturl
 
+import (
+	contextpkg "context"
+)
+
 //
 // GitURL
 //
@@ -14,7 +18,7 @@ func (self *Context) NewGitURL(path string, repositoryUrl string) *GitURL {
 	return &GitURL{self.NewMockURL("git", path, nil)}
 }
 
-func (self *Context) NewValidGitURL(path string, repositoryUrl string) (*GitURL, error) {
+func (self *Context) NewValidGitURL(context contextpkg.Context, path string, repositoryUrl string) (*GitURL, error) {
 	return nil, NewNotImplemented("NewValidGitURL")
 }
 
@@ -26,6 +30,6 @@ func (self *Context) ParseGitURL(url string) (*GitURL, error) {
 	return nil, NewNotImplemented("ParseGitURL")
 }
 
-func (self *Context) ParseValidGitURL(url string) (*GitURL, error) {
+func (self *Context) ParseValidGitURL(context contextpkg.Context, url string) (*GitURL, error) {
 	return nil, NewNotImplemented("ParseValidGitURL")
 }
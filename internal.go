@@ -1,7 +1,6 @@
 package exturl
 
 import (
-	"bytes"
 	contextpkg "context"
 	"embed"
 	"fmt"
@@ -183,7 +182,7 @@ func (self *Context) NewValidInternalURL(path string) (*InternalURL, error) {
 			urlContext: self,
 		}, nil
 	} else {
-		return nil, NewNotFoundf("internal URL not found: %s", path)
+		return nil, &NotFound{newURLError("internal:"+path, nil, "internal URL not found: %s", path)}
 	}
 }
 
@@ -230,22 +229,31 @@ func (self *InternalURL) Key() string {
 //
 // ([URL] interface)
 func (self *InternalURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *InternalURL) open(context contextpkg.Context) (io.ReadCloser, error) {
 	content := self.OverrideContent
 
 	if content == nil {
 		var ok bool
 		if content, ok = internal.Load(self.Path); !ok {
-			return nil, NewNotFoundf("internal URL not found: %s", self.Path)
+			return nil, &NotFound{newURLError(self.Key(), nil, "internal URL not found: %s", self.Path)}
 		}
 	}
 
 	if provider, ok := content.(InternalURLProvider); ok {
 		return provider.OpenPath(context, self.Path)
 	} else {
-		return io.NopCloser(bytes.NewReader(content.([]byte))), nil
+		return newBytesReader(content.([]byte)), nil
 	}
 }
 
+// ([RandomAccessURL] interface)
+func (self *InternalURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	return openRandomAccess(context, self)
+}
+
 // ([URL] interface)
 func (self *InternalURL) Context() *Context {
 	return self.urlContext
@@ -0,0 +1,129 @@
+package exturl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const pidFileSuffix = ".pid"
+
+// Deletes "exturl-*" temporary files and dirs in "dir" that were left behind by
+// processes that are no longer running, e.g. because they were killed or crashed
+// before they could release their contexts. "dir" can be an empty string to use
+// the default temporary directory.
+//
+// Ownership is determined by the ".pid" file that exturl writes next to each
+// temporary file and dir. Entries without a ".pid" file, or owned by processes
+// on other hosts, are left alone.
+func CleanStaleTemporaryFiles(dir string) error {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !strings.HasPrefix(name, "exturl-") || !strings.HasSuffix(name, pidFileSuffix) {
+			continue
+		}
+
+		pidPath := filepath.Join(dir, name)
+		if stale, err := isStale(pidPath); err == nil {
+			if stale {
+				path := strings.TrimSuffix(pidPath, pidFileSuffix)
+				log.Infof("deleting stale temporary file or dir %q", path)
+				if err := os.RemoveAll(path); err == nil {
+					deletePidFile(path)
+				} else {
+					log.Errorf("could not delete stale temporary file or dir %q: %s", path, err.Error())
+				}
+			}
+		} else {
+			log.Warningf("could not read %q: %s", pidPath, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Deletes stale temporary files and dirs in the context's temporary directory.
+// See [CleanStaleTemporaryFiles].
+func (self *Context) CleanStaleTemporaryFiles() error {
+	return CleanStaleTemporaryFiles(self.GetTemporaryDir())
+}
+
+// When true, stale temporary files and dirs (see [CleanStaleTemporaryFiles]) are
+// deleted from the context's temporary directory (see [Context.SetTemporaryDir])
+// before the context first stores a temporary file or dir in it.
+//
+// Child contexts clean if their parent does.
+//
+// Not thread-safe
+func (self *Context) SetCleanStaleTemporaryFiles(clean bool) {
+	self.cleanStaleFiles = clean
+}
+
+func (self *Context) IsCleanStaleTemporaryFiles() bool {
+	for context := self; context != nil; context = context.parent {
+		if context.cleanStaleFiles {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the directory in which to store temporary files and dirs, cleaning it
+// first if requested.
+func (self *Context) getCleanTemporaryDir() string {
+	dir := self.GetTemporaryDir()
+	if self.IsCleanStaleTemporaryFiles() {
+		self.cleanedStaleFiles.Do(func() {
+			if err := CleanStaleTemporaryFiles(dir); err != nil {
+				log.Errorf("could not clean stale temporary files: %s", err.Error())
+			}
+		})
+	}
+	return dir
+}
+
+// Marks a temporary file or dir as owned by this process.
+func writePidFile(path string) error {
+	hostname, _ := os.Hostname()
+	content := fmt.Sprintf("%d\n%s\n", os.Getpid(), hostname)
+	return os.WriteFile(path+pidFileSuffix, []byte(content), 0600)
+}
+
+func deletePidFile(path string) {
+	if err := os.Remove(path + pidFileSuffix); (err != nil) && !os.IsNotExist(err) {
+		log.Warningf("could not delete %q: %s", path+pidFileSuffix, err.Error())
+	}
+}
+
+func isStale(pidPath string) (bool, error) {
+	if content, err := os.ReadFile(pidPath); err == nil {
+		lines := strings.Split(string(content), "\n")
+		if len(lines) < 2 {
+			return false, fmt.Errorf("malformed pid file: %s", pidPath)
+		}
+
+		if pid, err := strconv.Atoi(lines[0]); err == nil {
+			if hostname, _ := os.Hostname(); lines[1] != hostname {
+				// We can't know if processes on other hosts are running
+				return false, nil
+			}
+
+			return !isProcessRunning(pid), nil
+		} else {
+			return false, err
+		}
+	} else {
+		return false, err
+	}
+}
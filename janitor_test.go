@@ -0,0 +1,75 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tliron/kutil/util"
+)
+
+func TestCleanStaleTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	hostname, _ := os.Hostname()
+
+	stalePath := filepath.Join(dir, "exturl-stale-1")
+	os.WriteFile(stalePath, nil, 0600)
+	// Hopefully there is no process with this pid
+	os.WriteFile(stalePath+pidFileSuffix, []byte(fmt.Sprintf("%d\n%s\n", 1<<30, hostname)), 0600)
+
+	livePath := filepath.Join(dir, "exturl-live-1")
+	os.WriteFile(livePath, nil, 0600)
+	writePidFile(livePath)
+
+	unownedPath := filepath.Join(dir, "exturl-unowned-1")
+	os.WriteFile(unownedPath, nil, 0600)
+
+	if err := CleanStaleTemporaryFiles(dir); err != nil {
+		t.Errorf("CleanStaleTemporaryFiles: %s", err.Error())
+		return
+	}
+
+	if ok, _ := util.DoesFileExist(stalePath); ok {
+		t.Error("stale file was not deleted")
+	}
+	if ok, _ := util.DoesFileExist(stalePath + pidFileSuffix); ok {
+		t.Error("stale pid file was not deleted")
+	}
+	if ok, _ := util.DoesFileExist(livePath); !ok {
+		t.Error("live file was deleted")
+	}
+	if ok, _ := util.DoesFileExist(unownedPath); !ok {
+		t.Error("unowned file was deleted")
+	}
+}
+
+func TestCleanStaleTemporaryFilesOnFirstUse(t *testing.T) {
+	dir := t.TempDir()
+	hostname, _ := os.Hostname()
+
+	stalePath := filepath.Join(dir, "exturl-stale-1")
+	os.WriteFile(stalePath, nil, 0600)
+	os.WriteFile(stalePath+pidFileSuffix, []byte(fmt.Sprintf("%d\n%s\n", 1<<30, hostname)), 0600)
+
+	context := NewContext()
+	defer context.Release()
+	context.SetTemporaryDir(dir)
+	context.SetCleanStaleTemporaryFiles(true)
+
+	if ok, _ := util.DoesFileExist(stalePath); !ok {
+		t.Error("stale file was deleted before first use")
+	}
+
+	url := context.NewInternalURL("/janitor-test")
+	url.SetContent("12345")
+	if _, err := context.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	if ok, _ := util.DoesFileExist(stalePath); ok {
+		t.Error("stale file was not deleted")
+	}
+}
@@ -0,0 +1,148 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	contextpkg "context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestArchiveLinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Ignores ranges, so the tarball will be streamed rather than indexed
+		writer.Write(linksTarball(t, true))
+	}))
+	defer server.Close()
+
+	for name, archiveUrl := range map[string]func(urlContext *exturl.Context) string{
+		"tar": func(urlContext *exturl.Context) string {
+			return "tar:" + urlContext.NewFileURL(writeFile(t, "links.tar", linksTarball(t, false))).String()
+		},
+		"tar.gz stream": func(urlContext *exturl.Context) string {
+			return "tar:" + server.URL + "/links.tar.gz"
+		},
+		"zip": func(urlContext *exturl.Context) string {
+			return "zip:" + urlContext.NewFileURL(writeFile(t, "links.zip", linksZip(t))).String()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			archiveUrl := archiveUrl(urlContext)
+
+			for _, path := range []string{"v1.2/main.yaml", "current/main.yaml", "latest.yaml", "hard.yaml"} {
+				if name == "zip" && path == "hard.yaml" {
+					continue
+				}
+
+				url := newURL(t, urlContext, archiveUrl+"!"+path)
+				if content, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				} else if content != "main: true\n" {
+					t.Errorf("%s: %q", path, content)
+				}
+
+				if _, err := url.ValidRelative(contextpkg.TODO(), "."); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				}
+			}
+
+			if _, err := exturl.ReadString(contextpkg.TODO(), newURL(t, urlContext, archiveUrl+"!escape/passwd")); !exturl.IsForbidden(err) {
+				t.Errorf("escape: %v", err)
+			}
+
+			if _, err := exturl.ReadString(contextpkg.TODO(), newURL(t, urlContext, archiveUrl+"!loop/a.yaml")); !exturl.IsMalformed(err) {
+				t.Errorf("loop: %v", err)
+			}
+
+			if name == "zip" {
+				// Zip symlink targets are limited in size
+				if _, err := exturl.ReadString(contextpkg.TODO(), newURL(t, urlContext, archiveUrl+"!long/main.yaml")); !exturl.IsMalformed(err) {
+					t.Errorf("long: %v", err)
+				}
+			}
+
+			if urls, err := newURL(t, urlContext, archiveUrl+"!current/").(exturl.ListableURL).List(contextpkg.TODO()); err != nil {
+				t.Errorf("list: %s", err.Error())
+			} else if (len(urls) != 1) || (urls[0].String() != archiveUrl+"!/current/main.yaml") {
+				t.Errorf("list: %v", urls)
+			}
+
+			statable := newURL(t, urlContext, archiveUrl+"!latest.yaml").(exturl.StatableURL)
+			if info, err := statable.Stat(contextpkg.TODO()); err != nil {
+				t.Errorf("stat: %s", err.Error())
+			} else if info.Mode()&fs.ModeSymlink == 0 {
+				t.Errorf("stat: %s", info.Mode())
+			}
+
+			statable = newURL(t, urlContext, archiveUrl+"!current/main.yaml").(exturl.StatableURL)
+			if info, err := statable.Stat(contextpkg.TODO()); err != nil {
+				t.Errorf("stat: %s", err.Error())
+			} else if !info.Mode().IsRegular() || (info.Size() != int64(len("main: true\n"))) {
+				t.Errorf("stat: %s %d", info.Mode(), info.Size())
+			}
+		})
+	}
+}
+
+func linksTarball(t *testing.T, gzip_ bool) []byte {
+	archiveFormat := "tar"
+	if gzip_ {
+		archiveFormat = "tar.gz"
+	}
+
+	return exturltest.TarballOf(t, archiveFormat,
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeDir, Name: "v1.2/", Mode: 0755}},
+		exturltest.TarballFile("v1.2/main.yaml", []byte("main: true\n")),
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "current", Linkname: "v1.2"}},
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "latest.yaml", Linkname: "current/main.yaml"}},
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeLink, Name: "hard.yaml", Linkname: "v1.2/main.yaml"}},
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: "../../etc"}},
+		exturltest.TarballEntry{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "loop", Linkname: "loop"}},
+	)
+}
+
+func linksZip(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+
+	for _, entry := range []struct {
+		name    string
+		content string
+		mode    fs.FileMode
+	}{
+		{"v1.2/main.yaml", "main: true\n", 0644},
+		{"current", "v1.2", fs.ModeSymlink | 0777},
+		{"latest.yaml", "current/main.yaml", fs.ModeSymlink | 0777},
+		{"escape", "../../etc", fs.ModeSymlink | 0777},
+		{"loop", "loop", fs.ModeSymlink | 0777},
+		{"long", strings.Repeat("v1.2/../", 1000) + "v1.2", fs.ModeSymlink | 0777},
+	} {
+		header := zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		header.SetMode(entry.mode)
+		if writer, err := zipWriter.CreateHeader(&header); err == nil {
+			if _, err := writer.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
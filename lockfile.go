@@ -0,0 +1,356 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//
+// LockfileMode
+//
+
+type LockfileMode int
+
+const (
+	// Every URL opened in the context is added to the lockfile, together with the
+	// SHA-256 hash of its content and, for "git:" URLs, the commit hash.
+	//
+	// If the content is closed before it is read to the end, the rest is read
+	// in order to hash it.
+	LockfileRecord LockfileMode = iota
+
+	// Opening a URL fails with [*VerificationFailed] if it is not in the lockfile,
+	// if it has no content hash in the lockfile, or if its content or commit hash
+	// differs from the one in the lockfile.
+	//
+	// Note that this requires reading the entire content into memory when opening,
+	// except when opening for random access, in which case the content is hashed
+	// via ReadAt before it is returned.
+	LockfileVerify
+)
+
+//
+// LockfileEntry
+//
+
+type LockfileEntry struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+	Commit string `json:"commit,omitempty"`
+
+	url URL
+}
+
+//
+// Lockfile
+//
+
+// Only URLs opened directly are recorded and verified. URLs opened internally, e.g.
+// archives opened in order to read their entries, are not.
+type Lockfile struct {
+	entries map[string]*LockfileEntry
+	lock    sync.Mutex
+}
+
+type lockfileContent struct {
+	Version int              `json:"version"`
+	Entries []*LockfileEntry `json:"entries"`
+}
+
+func NewLockfile() *Lockfile {
+	return &Lockfile{
+		entries: make(map[string]*LockfileEntry),
+	}
+}
+
+func ReadLockfile(reader io.Reader) (*Lockfile, error) {
+	var content lockfileContent
+	if err := json.NewDecoder(reader).Decode(&content); err == nil {
+		self := NewLockfile()
+		for _, entry := range content.Entries {
+			self.entries[entry.URL] = entry
+		}
+		return self, nil
+	} else {
+		return nil, &Malformed{newURLError("", err, "malformed lockfile: %s", err.Error())}
+	}
+}
+
+// Writes JSON with the entries sorted by URL.
+func (self *Lockfile) Write(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(lockfileContent{
+		Version: 1,
+		Entries: self.Entries(),
+	})
+}
+
+// Sorted by URL.
+func (self *Lockfile) Entries() []*LockfileEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entries := make([]*LockfileEntry, 0, len(self.entries))
+	for _, entry := range self.entries {
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a *LockfileEntry, b *LockfileEntry) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	return entries
+}
+
+// "url" is a URL key.
+func (self *Lockfile) Get(url string) (*LockfileEntry, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	entry, ok := self.entries[url]
+	return entry, ok
+}
+
+func (self *Lockfile) record(url URL, sha256 string, commit string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	key := url.Key()
+	if entry, ok := self.entries[key]; ok && (sha256 == "") {
+		// Don't lose a hash we already have
+		entry.url = url
+		return
+	}
+
+	self.entries[key] = &LockfileEntry{
+		URL:    key,
+		SHA256: sha256,
+		Commit: commit,
+		url:    url,
+	}
+}
+
+// Sets a lockfile for this context and its children.
+//
+// Not thread-safe
+func (self *Context) SetLockfile(lockfile *Lockfile, mode LockfileMode) {
+	self.lockfile = lockfile
+	self.lockfileMode = mode
+}
+
+// Returns nil if not set.
+//
+// Not thread-safe
+func (self *Context) GetLockfile() (*Lockfile, LockfileMode) {
+	if (self.lockfile == nil) && (self.parent != nil) {
+		return self.parent.GetLockfile()
+	}
+	return self.lockfile, self.lockfileMode
+}
+
+// Called by [Context.open]
+func (self *Lockfile) wrap(context contextpkg.Context, url URL, mode LockfileMode, reader io.ReadCloser) (io.ReadCloser, error) {
+	commit, err := getCommit(context, url)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	switch mode {
+	case LockfileRecord:
+		return forwardSeek(&lockfileReader{
+			ReadCloser: reader,
+			lockfile:   self,
+			url:        url,
+			commit:     commit,
+			hash:       sha256.New(),
+		}, reader), nil
+
+	case LockfileVerify:
+		defer reader.Close()
+
+		if content, err := io.ReadAll(reader); err == nil {
+			if err := self.verify(url, getSHA256(content), commit); err == nil {
+				return newBytesReader(content), nil
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+
+	default:
+		return reader, nil
+	}
+}
+
+// Called by [Context.open] for readers opened for random access, which are not
+// wrapped. Instead, their entire content is hashed via ReadAt. Closes the reader
+// on error.
+func (self *Lockfile) hashRandomAccess(context contextpkg.Context, url URL, mode LockfileMode, reader io.ReadCloser) error {
+	readerAt, ok := reader.(io.ReaderAt)
+	size := getReaderSize(reader)
+	if !ok || (size < 0) {
+		// Random access is not supported, so the reader will not be used
+		return nil
+	}
+
+	if (mode != LockfileRecord) && (mode != LockfileVerify) {
+		return nil
+	}
+
+	commit, err := getCommit(context, url)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(readerAt, 0, size)); err != nil {
+		reader.Close()
+		return err
+	}
+	sha256 := hex.EncodeToString(hash.Sum(nil))
+
+	if mode == LockfileRecord {
+		self.record(url, sha256, commit)
+		return nil
+	}
+
+	if err := self.verify(url, sha256, commit); err != nil {
+		reader.Close()
+		return err
+	}
+	return nil
+}
+
+// Returns a [VerificationFailed] error if the URL is not in the lockfile or its
+// content hash or commit differ.
+func (self *Lockfile) verify(url URL, sha256 string, commit string) error {
+	key := url.Key()
+	entry, ok := self.Get(key)
+	if !ok {
+		return &VerificationFailed{newURLError(key, nil, "not in lockfile: %s", key)}
+	}
+
+	if (entry.Commit != "") && (entry.Commit != commit) {
+		return &VerificationFailed{newURLError(key, nil, "commit %s differs from lockfile %s: %s", commit, entry.Commit, key)}
+	}
+
+	if entry.SHA256 == "" {
+		return &VerificationFailed{newURLError(key, nil, "no content hash in lockfile: %s", key)}
+	}
+
+	if sha256 != entry.SHA256 {
+		return &VerificationFailed{newURLError(key, nil, "content hash %s differs from lockfile %s: %s", sha256, entry.SHA256, key)}
+	}
+
+	return nil
+}
+
+//
+// lockfileReader
+//
+
+type lockfileReader struct {
+	io.ReadCloser
+	lockfile *Lockfile
+	url      URL
+	commit   string
+	hash     hash.Hash
+	done     bool
+}
+
+// ([io.Reader] interface)
+func (self *lockfileReader) Read(p []byte) (int, error) {
+	n, err := self.ReadCloser.Read(p)
+	self.hash.Write(p[:n])
+	if (err == io.EOF) && !self.done {
+		self.done = true
+		self.lockfile.record(self.url, hex.EncodeToString(self.hash.Sum(nil)), self.commit)
+	}
+	return n, err
+}
+
+// ([io.Closer] interface)
+func (self *lockfileReader) Close() error {
+	self.finish()
+	return self.ReadCloser.Close()
+}
+
+// Called by [seekingReader.Seek]. Seeking would break the hash, so we finish it
+// first.
+func (self *lockfileReader) beforeSeek() {
+	self.finish()
+}
+
+func (self *lockfileReader) finish() {
+	if !self.done {
+		self.done = true
+
+		// Not read to the end, so we read the rest in order to hash it
+		if _, err := io.Copy(self.hash, self.ReadCloser); err == nil {
+			self.lockfile.record(self.url, hex.EncodeToString(self.hash.Sum(nil)), self.commit)
+		} else {
+			// Recorded without a hash, which will fail verification
+			self.lockfile.record(self.url, "", self.commit)
+		}
+	}
+}
+
+func (self *lockfileReader) Size() int64 {
+	return getReaderSize(self.ReadCloser)
+}
+
+// Utils
+
+type nestedOpenKey struct{}
+
+// Marks the context as being used to open a URL internally, e.g. an archive
+// opened in order to read one of its entries.
+func nestedOpen(context contextpkg.Context) contextpkg.Context {
+	return contextpkg.WithValue(context, nestedOpenKey{}, true)
+}
+
+func isNestedOpen(context contextpkg.Context) bool {
+	nested, _ := context.Value(nestedOpenKey{}).(bool)
+	return nested
+}
+
+type randomAccessOpenKey struct{}
+
+// Marks the context as being used to open a URL for random access, in which
+// case [Context.open] will not wrap the reader (for metrics, faults, and
+// lockfiles), because the wrappers do not support random access. Instead, the
+// lockfile hashes the entire content via ReadAt.
+func randomAccessOpen(context contextpkg.Context) contextpkg.Context {
+	return contextpkg.WithValue(context, randomAccessOpenKey{}, true)
+}
+
+func isRandomAccessOpen(context contextpkg.Context) bool {
+	randomAccess, _ := context.Value(randomAccessOpenKey{}).(bool)
+	return randomAccess
+}
+
+// Returns an empty string if the URL has no commit
+func getCommit(context contextpkg.Context, url URL) (string, error) {
+	if commitUrl, ok := url.(interface {
+		Commit(context contextpkg.Context) (string, error)
+	}); ok {
+		return commitUrl.Commit(context)
+	}
+	return "", nil
+}
+
+func getSHA256(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,139 @@
+package exturl
+
+import (
+	"bytes"
+	contextpkg "context"
+	"testing"
+)
+
+func TestLockfile(t *testing.T) {
+	RegisterInternalURL("lockfile/a", "a")
+	defer DeregisterInternalURL("lockfile/a")
+	RegisterInternalURL("lockfile/b", "b")
+	defer DeregisterInternalURL("lockfile/b")
+
+	// Record
+
+	lockfile := NewLockfile()
+
+	context := NewContext()
+	defer context.Release()
+	context.SetLockfile(lockfile, LockfileRecord)
+
+	for _, url := range []string{"internal:lockfile/a", "internal:lockfile/b"} {
+		if _, err := testRead(context.NewChild(), url); err != nil {
+			t.Errorf("record: %s", err.Error())
+			return
+		}
+	}
+
+	// Closed without reading, so it must be hashed on close
+	RegisterInternalURL("lockfile/partial", "partial")
+	defer DeregisterInternalURL("lockfile/partial")
+	if url, err := context.NewURL("internal:lockfile/partial"); err == nil {
+		if reader, err := url.Open(contextpkg.TODO()); err == nil {
+			reader.Close()
+		} else {
+			t.Errorf("record partial: %s", err.Error())
+		}
+	} else {
+		t.Errorf("record partial: %s", err.Error())
+	}
+
+	var buffer bytes.Buffer
+	if err := lockfile.Write(&buffer); err != nil {
+		t.Errorf("write: %s", err.Error())
+		return
+	}
+
+	lockfile, err := ReadLockfile(&buffer)
+	if err != nil {
+		t.Errorf("read: %s", err.Error())
+		return
+	}
+
+	if entries := lockfile.Entries(); len(entries) != 3 {
+		t.Errorf("entries: %d", len(entries))
+		return
+	} else if entries[0].SHA256 != getSHA256([]byte("a")) {
+		t.Errorf("hash: %s", entries[0].SHA256)
+	} else if entries[2].SHA256 != getSHA256([]byte("partial")) {
+		t.Errorf("partial hash: %s", entries[2].SHA256)
+	}
+
+	// Verify
+
+	context = NewContext()
+	defer context.Release()
+	context.SetLockfile(lockfile, LockfileVerify)
+
+	if b, err := testRead(context, "internal:lockfile/a"); err != nil {
+		t.Errorf("verify: %s", err.Error())
+	} else if string(b) != "a" {
+		t.Errorf("verify content: %q", b)
+	}
+
+	UpdateInternalURL("lockfile/b", "changed")
+	if _, err := testRead(context, "internal:lockfile/b"); !IsVerificationFailed(err) {
+		t.Errorf("changed content: %v", err)
+	}
+
+	if entry, ok := lockfile.Get("internal:lockfile/a"); ok {
+		entry.SHA256 = ""
+		if _, err := testRead(context, "internal:lockfile/a"); !IsVerificationFailed(err) {
+			t.Errorf("no hash: %v", err)
+		}
+	}
+
+	RegisterInternalURL("lockfile/c", "c")
+	defer DeregisterInternalURL("lockfile/c")
+	if _, err := testRead(context, "internal:lockfile/c"); !IsVerificationFailed(err) {
+		t.Errorf("not in lockfile: %v", err)
+	}
+}
+
+func TestLockfileRandomAccess(t *testing.T) {
+	RegisterInternalURL("lockfile/random", "random")
+	defer DeregisterInternalURL("lockfile/random")
+
+	// Record
+
+	lockfile := NewLockfile()
+
+	context := NewContext()
+	defer context.Release()
+	context.SetLockfile(lockfile, LockfileRecord)
+
+	url := context.NewInternalURL("lockfile/random")
+	if reader, _, err := url.OpenRandomAccess(contextpkg.TODO()); err == nil {
+		reader.Close()
+	} else {
+		t.Errorf("record: %s", err.Error())
+		return
+	}
+
+	if entry, ok := lockfile.Get(url.Key()); !ok {
+		t.Errorf("not recorded")
+		return
+	} else if entry.SHA256 != getSHA256([]byte("random")) {
+		t.Errorf("hash: %s", entry.SHA256)
+	}
+
+	// Verify
+
+	context = NewContext()
+	defer context.Release()
+	context.SetLockfile(lockfile, LockfileVerify)
+
+	url = context.NewInternalURL("lockfile/random")
+	if reader, _, err := url.OpenRandomAccess(contextpkg.TODO()); err == nil {
+		reader.Close()
+	} else {
+		t.Errorf("verify: %s", err.Error())
+	}
+
+	UpdateInternalURL("lockfile/random", "changed")
+	if _, _, err := url.OpenRandomAccess(contextpkg.TODO()); !IsVerificationFailed(err) {
+		t.Errorf("changed content: %v", err)
+	}
+}
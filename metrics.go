@@ -0,0 +1,226 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Kinds of cache hits and misses (see [Metrics] and [EventCacheHit]).
+const (
+	CacheKindFile       = "file"       // downloaded file (see [Context.GetLocalPath])
+	CacheKindRepository = "repository" // cloned git repository
+	CacheKindZipIndex   = "zip index"  // see [ZipIndex]
+	CacheKindTarIndex   = "tar index"  // see [TarIndex]
+	CacheKindTarEntry   = "tar entry"  // see [PrefetchTarballEntries]
+)
+
+//
+// Metrics
+//
+
+// Receives measurements, e.g. to be reported as Prometheus metrics.
+//
+// "scheme" is the URL scheme, e.g. "https", "tar", "git". "kind" for cache
+// hits and misses is one of the CacheKind constants, e.g. [CacheKindFile].
+//
+// Bytes read via random access (see [RandomAccessURL]) are not counted. Each error
+// is counted once, by the outermost operation, e.g. a [Download] that fails to
+// open its URL counts a single error for the download.
+//
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	AddBytesRead(scheme string, bytes int64)
+	ObserveOpenLatency(scheme string, duration time.Duration)
+	AddCacheHit(kind string)
+	AddCacheMiss(kind string)
+	ObserveCloneDuration(duration time.Duration)
+	AddError(scheme string, errorType string)
+}
+
+//
+// Tracer
+//
+
+// Creates spans, e.g. to be reported via OpenTelemetry.
+//
+// Spans are started around [URL.Open], [Download], [GitURL.OpenRepository], and
+// [DockerURL.WriteTarball]. Span names are "exturl.Open", "exturl.Download",
+// "exturl.OpenRepository", and "exturl.WriteTarball" respectively.
+//
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// The returned context will be used for the traced operation, so it can be
+	// used to carry the span.
+	StartSpan(context contextpkg.Context, name string, url URL) (contextpkg.Context, Span)
+}
+
+//
+// Span
+//
+
+type Span interface {
+	// "err" is nil if the operation succeeded.
+	End(err error)
+}
+
+// Sets the metrics receiver for this context and its children.
+//
+// Not thread-safe
+func (self *Context) SetMetrics(metrics Metrics) {
+	self.metrics = metrics
+}
+
+// Returns nil if not set.
+//
+// Not thread-safe
+func (self *Context) GetMetrics() Metrics {
+	if (self.metrics == nil) && (self.parent != nil) {
+		return self.parent.GetMetrics()
+	}
+	return self.metrics
+}
+
+// Sets the tracer for this context and its children.
+//
+// Not thread-safe
+func (self *Context) SetTracer(tracer Tracer) {
+	self.tracer = tracer
+}
+
+// Returns nil if not set.
+//
+// Not thread-safe
+func (self *Context) GetTracer() Tracer {
+	if (self.tracer == nil) && (self.parent != nil) {
+		return self.parent.GetTracer()
+	}
+	return self.tracer
+}
+
+// Runs "f" within a span if there is a tracer.
+func (self *Context) trace(context contextpkg.Context, name string, url URL, f func(context contextpkg.Context) error) error {
+	if tracer := self.GetTracer(); tracer != nil {
+		context_, span := tracer.StartSpan(context, name, url)
+		err := f(context_)
+		span.End(err)
+		return err
+	} else {
+		return f(context)
+	}
+}
+
+type countErrorKey struct{}
+
+// Errors are counted only by the outermost operation, e.g. by [URL.Open] but not
+// again by a [GitURL.OpenRepository] or [Download] that it calls. Returns true if
+// this is the outermost operation, in which case it should call countError.
+func countErrorOnce(context contextpkg.Context) (contextpkg.Context, bool) {
+	if counted, _ := context.Value(countErrorKey{}).(bool); counted {
+		return context, false
+	}
+	return contextpkg.WithValue(context, countErrorKey{}, true), true
+}
+
+// For operations whose errors are not returned to the outermost operation.
+func countErrorAgain(context contextpkg.Context) contextpkg.Context {
+	return contextpkg.WithValue(context, countErrorKey{}, false)
+}
+
+func (self *Context) countError(url URL, err error) {
+	if metrics := self.GetMetrics(); metrics != nil {
+		metrics.AddError(GetScheme(url), GetErrorType(err))
+	}
+}
+
+// Emits an event and counts the hit or miss
+func (self *Context) cacheLookup(url URL, hit bool, kind string) {
+	if hit {
+		self.emitType(EventCacheHit, url, kind, nil)
+	} else {
+		self.emitType(EventCacheMiss, url, kind, nil)
+	}
+
+	if metrics := self.GetMetrics(); metrics != nil {
+		if hit {
+			metrics.AddCacheHit(kind)
+		} else {
+			metrics.AddCacheMiss(kind)
+		}
+	}
+}
+
+// Returns the URL's scheme, e.g. "https", "tar", "git".
+func GetScheme(url URL) string {
+	if _, ok := url.(*FileURL); ok {
+		// Relative file URLs have no scheme in their key
+		return "file"
+	}
+
+	key := url.Key()
+	if colon := strings.Index(key, ":"); colon != -1 {
+		return key[:colon]
+	} else {
+		return ""
+	}
+}
+
+// Returns a short, stable description of the error's type, suitable for use as
+// a metrics label.
+func GetErrorType(err error) string {
+	switch {
+	case IsNotFound(err):
+		return "not found"
+	case IsNotImplemented(err):
+		return "not implemented"
+	case IsUnauthorized(err):
+		return "unauthorized"
+	case IsForbidden(err):
+		return "forbidden"
+	case IsRateLimited(err):
+		return "rate limited"
+	case IsTimeout(err), errors.Is(err, contextpkg.DeadlineExceeded):
+		return "timeout"
+	case IsMalformed(err):
+		return "malformed"
+	case IsUnsupportedScheme(err):
+		return "unsupported scheme"
+	case IsQuotaExceeded(err):
+		return "quota exceeded"
+	case IsTooLarge(err):
+		return "too large"
+	case IsOffline(err):
+		return "offline"
+	case IsVerificationFailed(err):
+		return "verification failed"
+	case errors.Is(err, contextpkg.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
+
+//
+// metricsReader
+//
+
+type metricsReader struct {
+	io.ReadCloser
+	metrics Metrics
+	scheme  string
+}
+
+// ([io.Reader] interface)
+func (self *metricsReader) Read(p []byte) (int, error) {
+	n, err := self.ReadCloser.Read(p)
+	if n > 0 {
+		self.metrics.AddBytesRead(self.scheme, int64(n))
+	}
+	return n, err
+}
+
+func (self *metricsReader) Size() int64 {
+	return getReaderSize(self.ReadCloser)
+}
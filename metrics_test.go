@@ -0,0 +1,236 @@
+package exturl
+
+import (
+	"archive/zip"
+	"bytes"
+	contextpkg "context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	metrics := newTestMetrics()
+	tracer := newTestTracer()
+	context.SetMetrics(metrics)
+	context.SetTracer(tracer)
+
+	url := context.NewInternalURL("/metrics-test")
+	url.SetContent("12345")
+
+	if _, err := ReadBytes(contextpkg.TODO(), url); err != nil {
+		t.Errorf("ReadBytes: %s", err.Error())
+		return
+	}
+
+	if _, err := context.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	if _, err := context.GetLocalPath(contextpkg.TODO(), url); err != nil {
+		t.Errorf("GetLocalPath: %s", err.Error())
+		return
+	}
+
+	if _, err := ReadBytes(contextpkg.TODO(), context.NewInternalURL("/metrics-test-missing")); err == nil {
+		t.Error("expected an error")
+		return
+	}
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	if bytes := metrics.bytesRead["internal"]; bytes != 10 {
+		t.Errorf("bytes read: %d", bytes)
+	}
+	if count := metrics.openLatencies["internal"]; count != 3 {
+		t.Errorf("open latencies: %d", count)
+	}
+	if (metrics.cacheHits["file"] != 1) || (metrics.cacheMisses["file"] != 1) {
+		t.Errorf("cache hits and misses: %v %v", metrics.cacheHits, metrics.cacheMisses)
+	}
+	if count := metrics.errors["internal/not found"]; count != 1 {
+		t.Errorf("errors: %v", metrics.errors)
+	}
+
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+
+	if (tracer.spans["exturl.Open"] != 3) || (tracer.spans["exturl.Download"] != 1) {
+		t.Errorf("spans: %v", tracer.spans)
+	}
+	if tracer.errors != 1 {
+		t.Errorf("span errors: %d", tracer.errors)
+	}
+}
+
+func TestMetricsErrorsCountedOnce(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	metrics := newTestMetrics()
+	context.SetMetrics(metrics)
+
+	url := context.NewInternalURL("/metrics-test-missing")
+
+	if _, err := Download(contextpkg.TODO(), url, "exturl-metrics-test-*"); err == nil {
+		t.Error("expected an error")
+		return
+	}
+
+	if _, err := ReadBytes(contextpkg.TODO(), NewZipURL("entry", url)); err == nil {
+		t.Error("expected an error")
+		return
+	}
+
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+
+	if (len(metrics.errors) != 2) || (metrics.errors["internal/not found"] != 1) || (metrics.errors["zip/not found"] != 1) {
+		t.Errorf("errors: %v", metrics.errors)
+	}
+}
+
+func TestMetricsRandomAccess(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	dir := t.TempDir()
+	context.SetTemporaryDir(dir)
+	context.SetMetrics(newTestMetrics())
+	context.SetFaults(&Faults{ReadLatency: time.Nanosecond})
+
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	if writer, err := zipWriter.Create("a.txt"); err == nil {
+		if _, err := writer.Write([]byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		t.Fatal(err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	url := context.NewInternalURL("/metrics-test.zip")
+	url.SetContent(buffer.Bytes())
+
+	if content, err := ReadString(contextpkg.TODO(), NewZipURL("a.txt", url)); err != nil {
+		t.Errorf("ReadString: %s", err.Error())
+	} else if content != "a" {
+		t.Errorf("content: %q", content)
+	}
+
+	// The zip would have been downloaded if the wrapped reader had hidden its
+	// support for random access
+	if dirEntries, err := os.ReadDir(dir); err != nil {
+		t.Errorf("temporary dir: %s", err.Error())
+	} else if len(dirEntries) != 0 {
+		t.Errorf("temporary files: %d", len(dirEntries))
+	}
+}
+
+//
+// testMetrics
+//
+
+type testMetrics struct {
+	bytesRead      map[string]int64
+	openLatencies  map[string]int
+	cacheHits      map[string]int
+	cacheMisses    map[string]int
+	cloneDurations int
+	errors         map[string]int
+	lock           sync.Mutex
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		bytesRead:     make(map[string]int64),
+		openLatencies: make(map[string]int),
+		cacheHits:     make(map[string]int),
+		cacheMisses:   make(map[string]int),
+		errors:        make(map[string]int),
+	}
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) AddBytesRead(scheme string, bytes int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.bytesRead[scheme] += bytes
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) ObserveOpenLatency(scheme string, duration time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.openLatencies[scheme]++
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) AddCacheHit(kind string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.cacheHits[kind]++
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) AddCacheMiss(kind string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.cacheMisses[kind]++
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) ObserveCloneDuration(duration time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.cloneDurations++
+}
+
+// ([Metrics] interface)
+func (self *testMetrics) AddError(scheme string, errorType string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.errors[scheme+"/"+errorType]++
+}
+
+//
+// testTracer
+//
+
+type testTracer struct {
+	spans  map[string]int
+	errors int
+	lock   sync.Mutex
+}
+
+func newTestTracer() *testTracer {
+	return &testTracer{
+		spans: make(map[string]int),
+	}
+}
+
+// ([Tracer] interface)
+func (self *testTracer) StartSpan(context contextpkg.Context, name string, url URL) (contextpkg.Context, Span) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.spans[name]++
+	return context, self
+}
+
+// ([Span] interface)
+func (self *testTracer) End(err error) {
+	if err != nil {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		self.errors++
+	}
+}
@@ -1,7 +1,6 @@
 package exturl
 
 import (
-	"bytes"
 	contextpkg "context"
 	"io"
 	pathpkg "path"
@@ -11,15 +10,18 @@ import (
 // MockURL
 //
 
+// If Content is a [*MockFS], then Relative, ValidRelative, and List resolve
+// against it. Otherwise all derived URLs share the same Content.
 type MockURL struct {
 	Scheme  string
 	Path    string
 	Content any // []byte or InternalURLProvider
 
+	prefix     string // for mounted MockFS
 	urlContext *Context
 }
 
-// "content" can be []byte or an [InternalURLProvider].
+// "content" can be []byte or an [InternalURLProvider] (such as a [*MockFS]).
 // Other types will be converted to string and then to []byte.
 func (self *Context) NewMockURL(scheme string, path string, content any) *MockURL {
 	return &MockURL{
@@ -51,6 +53,7 @@ func (self *MockURL) Base() URL {
 		Scheme:     self.Scheme,
 		Path:       path,
 		Content:    self.Content,
+		prefix:     self.prefix,
 		urlContext: self.urlContext,
 	}
 }
@@ -61,26 +64,68 @@ func (self *MockURL) Relative(path string) URL {
 		Scheme:     self.Scheme,
 		Path:       pathpkg.Join(self.Path, path),
 		Content:    self.Content,
+		prefix:     self.prefix,
 		urlContext: self.urlContext,
 	}
 }
 
 // ([URL] interface)
 func (self *MockURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
-	return self.Relative(path), nil
+	url := self.Relative(path).(*MockURL)
+	if fs, ok := self.Content.(*MockFS); ok && !fs.Exists(url.Path) {
+		return nil, &NotFound{newURLError(url.Key(), nil, "mock path not found: %s", url.Key())}
+	}
+	return url, nil
 }
 
 // ([URL] interface)
 func (self *MockURL) Key() string {
+	if self.prefix != "" {
+		return self.prefix + self.Path
+	}
 	return self.Scheme + ":" + self.Path
 }
 
 // ([URL] interface)
 func (self *MockURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
-	if provider, ok := self.Content.(InternalURLProvider); ok {
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *MockURL) open(context contextpkg.Context) (io.ReadCloser, error) {
+	if fs, ok := self.Content.(*MockFS); ok {
+		return fs.openPath(context, self.Path, self.Key())
+	} else if provider, ok := self.Content.(InternalURLProvider); ok {
 		return provider.OpenPath(context, self.Path)
 	} else {
-		return io.NopCloser(bytes.NewReader(self.Content.([]byte))), nil
+		return newBytesReader(self.Content.([]byte)), nil
+	}
+}
+
+// ([RandomAccessURL] interface)
+func (self *MockURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	return openRandomAccess(context, self)
+}
+
+// ([ListableURL] interface)
+func (self *MockURL) List(context contextpkg.Context) ([]URL, error) {
+	if fs, ok := self.Content.(*MockFS); ok {
+		if paths, err := fs.list(self.Path, self.Key()); err == nil {
+			urls := make([]URL, len(paths))
+			for index, path := range paths {
+				urls[index] = &MockURL{
+					Scheme:     self.Scheme,
+					Path:       path,
+					Content:    self.Content,
+					prefix:     self.prefix,
+					urlContext: self.urlContext,
+				}
+			}
+			return urls, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, &NotImplemented{newURLError(self.Key(), nil, "mock URL without MockFS cannot be listed: %s", self.Key())}
 	}
 }
 
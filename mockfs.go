@@ -0,0 +1,260 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"io"
+	pathpkg "path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+//
+// MockFS
+//
+
+// In-memory tree of files and directories for testing. Mount it on a context via
+// [Context.MountMockFS] so that URLs under a prefix will resolve against it.
+//
+// Paths are always absolute and use "/" as the separator.
+type MockFS struct {
+	files map[string]any // []byte or InternalURLProvider
+	dirs  map[string]struct{}
+	lock  sync.RWMutex
+}
+
+func NewMockFS() *MockFS {
+	return &MockFS{
+		files: make(map[string]any),
+		dirs:  map[string]struct{}{"/": {}},
+	}
+}
+
+// Adds or replaces a file. Parent directories are added as necessary.
+//
+// "content" can be []byte or an [InternalURLProvider].
+// Other types will be converted to string and then to []byte.
+func (self *MockFS) Add(path string, content any) {
+	path = cleanMockPath(path)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.files[path] = fixInternalUrlContent(content)
+	self.addDir(pathpkg.Dir(path))
+}
+
+// Adds a directory. Parent directories are added as necessary.
+func (self *MockFS) AddDir(path string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.addDir(cleanMockPath(path))
+}
+
+// Removes a file or a directory together with all its descendants.
+func (self *MockFS) Remove(path string) {
+	path = cleanMockPath(path)
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.files, path)
+	for file := range self.files {
+		if strings.HasPrefix(file, prefix) {
+			delete(self.files, file)
+		}
+	}
+
+	if path != "/" {
+		delete(self.dirs, path)
+	}
+	for dir := range self.dirs {
+		if strings.HasPrefix(dir, prefix) {
+			delete(self.dirs, dir)
+		}
+	}
+}
+
+// Returns true if the path is a file or a directory.
+func (self *MockFS) Exists(path string) bool {
+	path = cleanMockPath(path)
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if _, ok := self.files[path]; ok {
+		return true
+	}
+	_, ok := self.dirs[path]
+	return ok
+}
+
+func (self *MockFS) IsDir(path string) bool {
+	path = cleanMockPath(path)
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	_, ok := self.dirs[path]
+	return ok
+}
+
+// Returns the sorted paths of the direct children of a directory. Children that
+// are directories have a trailing "/".
+func (self *MockFS) List(path string) ([]string, error) {
+	return self.list(path, "")
+}
+
+// "key" is the URL key for errors, and can be empty if unknown.
+func (self *MockFS) list(path string, key string) ([]string, error) {
+	path = cleanMockPath(path)
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if _, ok := self.dirs[path]; !ok {
+		return nil, &NotFound{newURLError(key, nil, "mock directory not found: %s", path)}
+	}
+
+	var paths []string
+	for file := range self.files {
+		if isMockChild(prefix, file) {
+			paths = append(paths, file)
+		}
+	}
+	for dir := range self.dirs {
+		if isMockChild(prefix, dir) {
+			paths = append(paths, dir+"/")
+		}
+	}
+
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// ([InternalURLProvider] interface)
+func (self *MockFS) OpenPath(context contextpkg.Context, path string) (io.ReadCloser, error) {
+	return self.openPath(context, path, "")
+}
+
+// "key" is the URL key for errors, and can be empty if unknown.
+func (self *MockFS) openPath(context contextpkg.Context, path string, key string) (io.ReadCloser, error) {
+	path = cleanMockPath(path)
+
+	self.lock.RLock()
+	content, ok := self.files[path]
+	_, isDir := self.dirs[path]
+	self.lock.RUnlock()
+
+	if ok {
+		if provider, ok := content.(InternalURLProvider); ok {
+			return provider.OpenPath(context, path)
+		} else {
+			return newBytesReader(content.([]byte)), nil
+		}
+	} else if isDir {
+		return nil, &Malformed{newURLError(key, nil, "mock path is a directory: %s", path)}
+	} else {
+		return nil, &NotFound{newURLError(key, nil, "mock file not found: %s", path)}
+	}
+}
+
+// Not thread-safe
+func (self *MockFS) addDir(path string) {
+	for {
+		self.dirs[path] = struct{}{}
+		if path == "/" {
+			return
+		}
+		path = pathpkg.Dir(path)
+	}
+}
+
+//
+// mockMount
+//
+
+type mockMount struct {
+	prefix string
+	fs     *MockFS
+}
+
+// Mounts a [MockFS] so that [Context.NewURL], [Context.NewValidURL], and their
+// variants will return a [*MockURL] for URLs starting with "prefix". The rest of
+// the URL is used as the path in the MockFS. Relative URLs derived from the
+// returned URLs will also resolve against the MockFS.
+//
+// "prefix" can be just a scheme (e.g. "https:") or a longer prefix (e.g.
+// "https://site.org" or "git:https://github.com/user/repo.git!"). If several
+// mounts match a URL, the longest prefix wins.
+//
+// Set "fs" to nil to unmount.
+//
+// Not thread-safe
+func (self *Context) MountMockFS(prefix string, fs *MockFS) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	self.mockMounts = copyOnWrite(self.mockMounts, self.parent, (*Context).getMockMounts)
+
+	if fs == nil {
+		delete(self.mockMounts, prefix)
+	} else {
+		self.mockMounts[prefix] = fs
+	}
+}
+
+func (self *Context) getMockMounts() map[string]*MockFS {
+	if (self.mockMounts == nil) && (self.parent != nil) {
+		return self.parent.getMockMounts()
+	}
+	return self.mockMounts
+}
+
+// Returns nil if the URL is not under a mount
+func (self *Context) newMountedMockURL(url string) *MockURL {
+	var found mockMount
+	for prefix, fs := range self.getMockMounts() {
+		if (len(prefix) > len(found.prefix)) && strings.HasPrefix(url, prefix) {
+			rest := url[len(prefix):]
+			if (rest == "") || strings.HasPrefix(rest, "/") || strings.HasSuffix(prefix, ":") || strings.HasSuffix(prefix, "!") {
+				found = mockMount{prefix, fs}
+			}
+		}
+	}
+
+	if found.fs == nil {
+		return nil
+	}
+
+	rest := url[len(found.prefix):]
+	if strings.HasSuffix(found.prefix, ":") && strings.HasPrefix(rest, "//") {
+		// Keep the authority's "//" in the key, e.g. "https://site.org/file"
+		found.prefix += "/"
+		rest = rest[1:]
+	}
+
+	scheme, _, _ := strings.Cut(found.prefix, ":")
+	return &MockURL{
+		Scheme:     scheme,
+		Path:       cleanMockPath(rest),
+		Content:    found.fs,
+		prefix:     found.prefix,
+		urlContext: self,
+	}
+}
+
+// Utils
+
+func cleanMockPath(path string) string {
+	return pathpkg.Clean("/" + path)
+}
+
+func isMockChild(prefix string, path string) bool {
+	if strings.HasPrefix(path, prefix) {
+		rest := path[len(prefix):]
+		return (rest != "") && !strings.Contains(rest, "/")
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"errors"
+	"testing"
+)
+
+func TestMockFS(t *testing.T) {
+	context := NewContext()
+	defer context.Release()
+
+	fs := NewMockFS()
+	fs.Add("/main.yaml", "main")
+	fs.Add("/imports/a.yaml", "a")
+	fs.Add("/imports/b.yaml", "b")
+	context.MountMockFS("https://site.org", fs)
+
+	url, err := context.NewURL("https://site.org/main.yaml")
+	if err != nil {
+		t.Errorf("new: %s", err.Error())
+		return
+	}
+
+	if key := url.Key(); key != "https://site.org/main.yaml" {
+		t.Errorf("key: %s", key)
+	}
+
+	if b, err := ReadBytes(contextpkg.TODO(), url.Base().Relative("imports/a.yaml")); err != nil {
+		t.Errorf("relative: %s", err.Error())
+	} else if string(b) != "a" {
+		t.Errorf("relative content: %q", b)
+	}
+
+	if url, err := context.NewValidURL(contextpkg.TODO(), "imports/b.yaml", []URL{url.Base()}); err != nil {
+		t.Errorf("valid relative: %s", err.Error())
+	} else if b, _ := ReadBytes(contextpkg.TODO(), url); string(b) != "b" {
+		t.Errorf("valid relative content: %q", b)
+	}
+
+	if _, err := context.NewValidURL(contextpkg.TODO(), "imports/missing.yaml", []URL{url.Base()}); !IsNotFound(err) {
+		t.Errorf("missing relative: %v", err)
+	}
+
+	if _, err := context.NewValidURL(contextpkg.TODO(), "https://site.org/missing.yaml", nil); !IsNotFound(err) {
+		t.Errorf("missing absolute: %v", err)
+	}
+
+	if urls, err := url.Base().(ListableURL).List(contextpkg.TODO()); err != nil {
+		t.Errorf("list: %s", err.Error())
+	} else if (len(urls) != 2) || (urls[0].Key() != "https://site.org/imports/") || (urls[1].Key() != "https://site.org/main.yaml") {
+		t.Errorf("list: %v", urls)
+	}
+
+	missingUrl := url.Base().Relative("imports/missing.yaml")
+	var notFound *NotFound
+	if _, err := missingUrl.Open(contextpkg.TODO()); !errors.As(err, &notFound) || (notFound.URL != missingUrl.Key()) {
+		t.Errorf("missing open: %v", err)
+	}
+
+	dirUrl := url.Base().Relative("imports")
+	var malformed *Malformed
+	if _, err := dirUrl.Open(contextpkg.TODO()); !errors.As(err, &malformed) || (malformed.URL != dirUrl.Key()) {
+		t.Errorf("dir open: %v", err)
+	}
+
+	// Longest prefix wins
+
+	gitFs := NewMockFS()
+	gitFs.Add("file.yaml", "git")
+	context.MountMockFS("git:https://github.com/user/repo.git!", gitFs)
+	context.MountMockFS("git:", fs)
+
+	if b, err := testRead(context, "git:https://github.com/user/repo.git!file.yaml"); err != nil {
+		t.Errorf("git: %s", err.Error())
+	} else if string(b) != "git" {
+		t.Errorf("git content: %q", b)
+	}
+}
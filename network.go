@@ -2,10 +2,10 @@ package exturl
 
 import (
 	contextpkg "context"
-	"fmt"
 	"io"
 	"net/http"
 	neturlpkg "net/url"
+	"os"
 	"path"
 )
 
@@ -31,8 +31,12 @@ func (self *Context) NewNetworkURL(neturl *neturlpkg.URL) *NetworkURL {
 }
 
 func (self *Context) NewValidNetworkURL(neturl *neturlpkg.URL) (*NetworkURL, error) {
+	return self.newValidNetworkURL(contextpkg.TODO(), neturl)
+}
+
+func (self *Context) newValidNetworkURL(context contextpkg.Context, neturl *neturlpkg.URL) (*NetworkURL, error) {
 	string_ := neturl.String()
-	if response, err := http.Head(string_); err == nil {
+	if response, err := self.httpDo(context, http.MethodHead, neturl, nil); err == nil {
 		response.Body.Close()
 		if response.StatusCode == http.StatusOK {
 			return &NetworkURL{
@@ -41,10 +45,10 @@ func (self *Context) NewValidNetworkURL(neturl *neturlpkg.URL) (*NetworkURL, err
 				urlContext: self,
 			}, nil
 		} else {
-			return nil, fmt.Errorf("HTTP status: %s", response.Status)
+			return nil, errorFromHTTPResponse(string_, response)
 		}
 	} else {
-		return nil, err
+		return nil, errorFromNet(string_, err)
 	}
 }
 
@@ -65,13 +69,7 @@ func (self *NetworkURL) Format() string {
 
 // ([URL] interface)
 func (self *NetworkURL) Base() URL {
-	url := *self
-	url.URL.Path = path.Dir(url.URL.Path)
-	if url.URL.Path != "/" {
-		url.URL.Path += "/"
-	}
-	// TODO: url.URL.RawPath?
-	return &url
+	return self.urlContext.NewNetworkURL(getBaseNetURL(self.URL))
 }
 
 // ([URL] interface)
@@ -87,9 +85,9 @@ func (self *NetworkURL) Relative(path string) URL {
 func (self *NetworkURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
 	if neturl, err := neturlpkg.Parse(path); err == nil {
 		neturl = self.URL.ResolveReference(neturl)
-		return self.urlContext.NewValidNetworkURL(neturl)
+		return self.urlContext.newValidNetworkURL(context, neturl)
 	} else {
-		return nil, err
+		return nil, &Malformed{newURLError(path, err, "malformed relative URL: %s", path)}
 	}
 }
 
@@ -100,15 +98,47 @@ func (self *NetworkURL) Key() string {
 
 // ([URL] interface)
 func (self *NetworkURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
-	if response, err := http.Get(self.string_); err == nil {
+	return self.urlContext.open(context, self, self.open)
+}
+
+func (self *NetworkURL) open(context contextpkg.Context) (io.ReadCloser, error) {
+	if response, err := self.urlContext.httpDo(context, http.MethodGet, self.URL, nil); err == nil {
 		if response.StatusCode == http.StatusOK {
-			return response.Body, nil
+			return &networkReader{response.Body, response.ContentLength}, nil
 		} else {
 			response.Body.Close()
-			return nil, fmt.Errorf("HTTP status: %s", response.Status)
+			return nil, errorFromHTTPResponse(self.string_, response)
 		}
 	} else {
-		return nil, err
+		return nil, errorFromNet(self.string_, err)
+	}
+}
+
+// ([RandomAccessURL] interface)
+//
+// Uses the downloaded file if available, otherwise a [RangeReaderAt].
+func (self *NetworkURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	if entry, err := self.urlContext.getTemporaryEntry(self.string_, false); err == nil {
+		if entry != nil {
+			if file, err := os.Open(entry.path); err == nil {
+				if stat, err := file.Stat(); err == nil {
+					return file, stat.Size(), nil
+				} else {
+					file.Close()
+					return nil, 0, err
+				}
+			} else {
+				return nil, 0, errorFromOS(self.string_, err)
+			}
+		}
+	} else {
+		return nil, 0, err
+	}
+
+	if rangeReaderAt, err := NewRangeReaderAt(context, self); err == nil {
+		return rangeReaderAt, rangeReaderAt.Size, nil
+	} else {
+		return nil, 0, err
 	}
 }
 
@@ -116,3 +146,64 @@ func (self *NetworkURL) Open(context contextpkg.Context) (io.ReadCloser, error)
 func (self *NetworkURL) Context() *Context {
 	return self.urlContext
 }
+
+// Returns a copy with the path's last element removed and a trailing slash
+func getBaseNetURL(neturl *neturlpkg.URL) *neturlpkg.URL {
+	base := *neturl
+	base.RawPath = ""
+	if base.Path == "" {
+		base.Path = "/"
+	} else {
+		base.Path = path.Dir(base.Path)
+		if base.Path != "/" {
+			base.Path += "/"
+		}
+	}
+	return &base
+}
+
+// Uses the round tripper and credentials set for the host, if any. "header" can
+// be nil.
+func (self *Context) httpDo(context contextpkg.Context, method string, neturl *neturlpkg.URL, header http.Header) (*http.Response, error) {
+	if self.IsOffline() {
+		return nil, &Offline{newURLError(neturl.String(), nil, "offline: %s", neturl.String())}
+	}
+
+	request, err := http.NewRequestWithContext(context, method, neturl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	if credentials := self.GetCredentials(neturl.Host); credentials != nil {
+		if credentials.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+credentials.Token)
+		} else if credentials.Username != "" {
+			request.SetBasicAuth(credentials.Username, credentials.Password)
+		}
+	}
+
+	client := http.DefaultClient
+	if httpRoundTripper := self.GetHTTPRoundTripper(neturl.Host); httpRoundTripper != nil {
+		client = &http.Client{Transport: httpRoundTripper}
+	}
+
+	return client.Do(request)
+}
+
+//
+// networkReader
+//
+
+type networkReader struct {
+	io.ReadCloser
+	size int64 // -1 if unknown
+}
+
+// Content-Length
+func (self *networkReader) Size() int64 {
+	return self.size
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package exturl
+
+func isProcessRunning(pid int) bool {
+	// We have no way of knowing, so we must assume it is
+	return true
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package exturl
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isProcessRunning(pid int) bool {
+	// Signal 0 checks for existence without actually sending a signal
+	err := syscall.Kill(pid, 0)
+	return (err == nil) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package exturl
+
+import (
+	"os"
+)
+
+func isProcessRunning(pid int) bool {
+	// On Windows FindProcess fails if the process does not exist
+	if process, err := os.FindProcess(pid); err == nil {
+		process.Release()
+		return true
+	} else {
+		return false
+	}
+}
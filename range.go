@@ -0,0 +1,226 @@
+package exturl
+
+import (
+	contextpkg "context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Size of the blocks fetched by [RangeReaderAt].
+var RangeBlockSize int64 = 64 * 1024
+
+// Maximum number of blocks cached by each [RangeReaderAt].
+var RangeMaxBlocks = 256
+
+//
+// RangeReaderAt
+//
+
+// An [io.ReaderAt] for a remote file (e.g. a [NetworkURL]) that fetches blocks of
+// [RangeBlockSize] bytes via HTTP range requests. The most recently fetched
+// blocks are cached, up to [RangeMaxBlocks].
+//
+// This allows for random access to large remote files, e.g. reading a single
+// entry of a zip, without downloading them in their entirety.
+//
+// Blocks are fetched with an "If-Range" header set to the ETag (or
+// Last-Modified) of the first block, so that if the file changes while being
+// read reads will fail with a [VerificationFailed] error rather than mix content
+// from two versions.
+type RangeReaderAt struct {
+	URL  URL
+	Size int64
+
+	context   contextpkg.Context
+	get       rangeGetFunc
+	validator string // for "If-Range"
+	blocks    map[int64][]byte
+	order     []int64 // oldest first
+	lock      sync.Mutex
+}
+
+// Sends a GET request with the header
+type rangeGetFunc func(context contextpkg.Context, header http.Header) (*http.Response, error)
+
+// Returns a [NotImplemented] error if the server does not support range requests
+// or does not report the size.
+//
+// The context is used for all subsequent reads.
+func NewRangeReaderAt(context contextpkg.Context, url *NetworkURL) (*RangeReaderAt, error) {
+	return newRangeReaderAt(context, url, func(context contextpkg.Context, header http.Header) (*http.Response, error) {
+		return url.urlContext.httpDo(context, http.MethodGet, url.URL, header)
+	})
+}
+
+// Fetches the first block in order to make sure that the server supports range
+// requests. Servers may advertise support (via "Accept-Ranges") and yet ignore
+// ranges, e.g. behind some CDNs.
+func newRangeReaderAt(context contextpkg.Context, url URL, get rangeGetFunc) (*RangeReaderAt, error) {
+	self := RangeReaderAt{
+		URL:     url,
+		Size:    -1,
+		context: context,
+		get:     get,
+		blocks:  make(map[int64][]byte),
+	}
+
+	if block, err := self.fetchBlock(0); err == nil {
+		self.addBlock(0, block)
+		return &self, nil
+	} else {
+		return nil, err
+	}
+}
+
+// ([io.ReaderAt] interface)
+func (self *RangeReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d", offset)
+	}
+
+	var count int
+	for count < len(p) {
+		position := offset + int64(count)
+		if position >= self.Size {
+			return count, io.EOF
+		}
+
+		index := position / RangeBlockSize
+		if block, err := self.getBlock(index); err == nil {
+			count += copy(p[count:], block[position-index*RangeBlockSize:])
+		} else {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// ([io.Closer] interface)
+//
+// Releases the cached blocks.
+func (self *RangeReaderAt) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.blocks = make(map[int64][]byte)
+	self.order = nil
+	return nil
+}
+
+func (self *RangeReaderAt) getBlock(index int64) ([]byte, error) {
+	self.lock.Lock()
+	block, ok := self.blocks[index]
+	self.lock.Unlock()
+
+	if ok {
+		return block, nil
+	}
+
+	// We don't hold the lock while fetching, so that concurrent reads of
+	// different blocks can proceed in parallel
+	if block, err := self.fetchBlock(index); err == nil {
+		self.lock.Lock()
+		self.addBlock(index, block)
+		self.lock.Unlock()
+		return block, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Sets Size and validator when fetching the first block.
+func (self *RangeReaderAt) fetchBlock(index int64) ([]byte, error) {
+	key := self.URL.Key()
+	start := index * RangeBlockSize
+	end := start + RangeBlockSize - 1
+	if self.Size >= 0 {
+		end = min(end, self.Size-1)
+	}
+
+	header := make(http.Header)
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if self.validator != "" {
+		header.Set("If-Range", self.validator)
+	}
+
+	response, err := self.get(self.context, header)
+	if err != nil {
+		return nil, errorFromNet(key, err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if self.Size < 0 {
+			// The server ignored our range and is sending us everything
+			return nil, NewNotImplementedf("server does not support range requests: %s", key)
+		} else {
+			// The validator no longer matches
+			return nil, &VerificationFailed{newURLError(key, nil, "changed while being read: %s", key)}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// E.g. an empty file
+		return nil, NewNotImplementedf("server cannot serve ranges: %s", key)
+	default:
+		return nil, errorFromHTTPResponse(key, response)
+	}
+
+	contentRange := response.Header.Get("Content-Range")
+	start_, end_, size, ok := parseContentRange(contentRange)
+	if !ok {
+		return nil, NewNotImplementedf("server sent an unsupported Content-Range %q: %s", contentRange, key)
+	}
+
+	if self.Size < 0 {
+		self.Size = size
+		if etag := response.Header.Get("ETag"); (etag != "") && !strings.HasPrefix(etag, "W/") {
+			// Weak ETags cannot be used with "If-Range"
+			self.validator = etag
+		} else {
+			self.validator = response.Header.Get("Last-Modified")
+		}
+	} else if size != self.Size {
+		return nil, &VerificationFailed{newURLError(key, nil, "changed while being read: %s", key)}
+	}
+
+	// Servers may send less than we asked for, but we rely on full blocks
+	if (start_ != start) || (end_ != min(end, size-1)) {
+		return nil, NewNotImplementedf("server sent an unsupported Content-Range %q: %s", contentRange, key)
+	}
+
+	block := make([]byte, end_-start_+1)
+	if _, err := io.ReadFull(response.Body, block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// Call while holding the lock (or before the reader is shared).
+func (self *RangeReaderAt) addBlock(index int64, block []byte) {
+	if _, ok := self.blocks[index]; ok {
+		// Fetched concurrently
+		return
+	}
+
+	if len(self.order) >= RangeMaxBlocks {
+		delete(self.blocks, self.order[0])
+		self.order = self.order[1:]
+	}
+	self.blocks[index] = block
+	self.order = append(self.order, index)
+}
+
+// Parses "bytes start-end/size". The size must be known.
+func parseContentRange(contentRange string) (int64, int64, int64, bool) {
+	var start, end, size int64
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err == nil {
+		return start, end, size, (end < size)
+	}
+	return 0, 0, 0, false
+}
@@ -0,0 +1,309 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"archive/zip"
+	"bytes"
+	contextpkg "context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestZipRange(t *testing.T) {
+	content := bigZip(t)
+
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(&countingResponseWriter{writer, &served}, request, "big.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "zip:"+server.URL+"/big.zip!manifest.yaml")
+	if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+		t.Errorf("read: %s", err.Error())
+	} else if manifest != "manifest: true\n" {
+		t.Errorf("content: %q", manifest)
+	}
+
+	if served := served.Load(); served > int64(len(content))/4 {
+		t.Errorf("served %d bytes of %d", served, len(content))
+	}
+}
+
+func TestZipNoRange(t *testing.T) {
+	content := bigZip(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Ignores ranges
+		writer.Write(content)
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "zip:"+server.URL+"/big.zip!manifest.yaml")
+	if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+		t.Errorf("read: %s", err.Error())
+	} else if manifest != "manifest: true\n" {
+		t.Errorf("content: %q", manifest)
+	}
+}
+
+func TestZipIgnoredRange(t *testing.T) {
+	content := bigZip(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Advertises ranges but ignores them, like some CDNs
+		writer.Header().Set("Accept-Ranges", "bytes")
+		writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if request.Method != http.MethodHead {
+			writer.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "zip:"+server.URL+"/big.zip!manifest.yaml")
+	if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+		t.Errorf("read: %s", err.Error())
+	} else if manifest != "manifest: true\n" {
+		t.Errorf("content: %q", manifest)
+	}
+}
+
+func TestZipChangedRange(t *testing.T) {
+	versions := [][]byte{bigZip(t), bigZip(t)}
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Changes after the first request
+		version := min(requests.Add(1)-1, 1)
+		writer.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+		http.ServeContent(writer, request, "big.zip", time.Time{}, bytes.NewReader(versions[version]))
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "zip:"+server.URL+"/big.zip!manifest.yaml")
+	if _, err := exturl.ReadString(contextpkg.TODO(), url); !exturl.IsVerificationFailed(err) {
+		t.Errorf("not VerificationFailed: %v", err)
+	}
+}
+
+func TestTarRange(t *testing.T) {
+	content := bigTarball(t)
+
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(&countingResponseWriter{writer, &served}, request, "big.tar", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "tar:"+server.URL+"/big.tar!manifest.yaml")
+	for range 3 {
+		if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+			t.Errorf("read: %s", err.Error())
+		} else if manifest != "manifest: true\n" {
+			t.Errorf("content: %q", manifest)
+		}
+	}
+
+	if served := served.Load(); served > int64(len(content))/4 {
+		t.Errorf("served %d bytes of %d", served, len(content))
+	}
+}
+
+func TestTarCompressedRange(t *testing.T) {
+	content := exturltest.TarballOf(t, "tar.gz",
+		exturltest.TarballFile("big.bin", bigTarball(t)),
+		exturltest.TarballFile("manifest.yaml", []byte("manifest: true\n")),
+	)
+
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(&countingResponseWriter{writer, &served}, request, "big.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	// Fetched once (after probing for range support), and then read locally
+	url := newURL(t, urlContext, "tar:"+server.URL+"/big.tar.gz!manifest.yaml")
+	for range 3 {
+		if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+			t.Errorf("read: %s", err.Error())
+		} else if manifest != "manifest: true\n" {
+			t.Errorf("content: %q", manifest)
+		}
+	}
+
+	if served := served.Load(); served > int64(len(content))+2*exturl.RangeBlockSize {
+		t.Errorf("served %d bytes of %d", served, len(content))
+	}
+}
+
+func TestTarTOCRange(t *testing.T) {
+	content := exturltest.Estargz(t, bigTarball(t), 0)
+
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeContent(&countingResponseWriter{writer, &served}, request, "big.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "tar:"+server.URL+"/big.tar.gz!manifest.yaml")
+	for range 3 {
+		if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+			t.Errorf("read: %s", err.Error())
+		} else if manifest != "manifest: true\n" {
+			t.Errorf("content: %q", manifest)
+		}
+	}
+
+	if _, _, err := url.(exturl.RandomAccessURL).OpenRandomAccess(contextpkg.TODO()); err != nil {
+		t.Errorf("random access: %s", err.Error())
+	}
+
+	if served := served.Load(); served > int64(len(content))/4 {
+		t.Errorf("served %d bytes of %d", served, len(content))
+	}
+}
+
+func TestDockerTOCRange(t *testing.T) {
+	content := exturltest.Estargz(t, bigTarball(t), 0)
+
+	// The registry ignores ranges for blobs, so we serve them ourselves
+	var served atomic.Int64
+	registry_ := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if (request.Method == http.MethodGet) && strings.Contains(request.URL.Path, "/blobs/") {
+			recorder := httptest.NewRecorder()
+			registry_.ServeHTTP(recorder, request)
+			if recorder.Code == http.StatusOK {
+				http.ServeContent(&countingResponseWriter{writer, &served}, request, "", time.Time{}, bytes.NewReader(recorder.Body.Bytes()))
+				return
+			}
+		}
+		registry_.ServeHTTP(writer, request)
+	}))
+	defer server.Close()
+
+	host := server.Listener.Addr().String()
+	tag, err := name.NewTag(host + "/exturltest/big:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.Write(tag, image); err != nil {
+		t.Fatal(err)
+	}
+	served.Store(0)
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	url := newURL(t, urlContext, "tar:docker://"+host+"/exturltest/big:latest!manifest.yaml")
+	if manifest, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+		t.Errorf("read: %s", err.Error())
+	} else if manifest != "manifest: true\n" {
+		t.Errorf("content: %q", manifest)
+	}
+
+	if served := served.Load(); served > int64(len(content))/4 {
+		t.Errorf("served %d bytes of %d", served, len(content))
+	}
+}
+
+// A tarball with a large incompressible entry followed by a small manifest
+func bigTarball(t *testing.T) []byte {
+	big := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(0)).Read(big)
+
+	return exturltest.TarballOf(t, "tar",
+		exturltest.TarballFile("big.bin", big),
+		exturltest.TarballFile("manifest.yaml", []byte("manifest: true\n")),
+	)
+}
+
+// A zip with a small manifest and a large incompressible entry
+func bigZip(t *testing.T) []byte {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+
+	if writer, err := zipWriter.Create("manifest.yaml"); err == nil {
+		writer.Write([]byte("manifest: true\n"))
+	} else {
+		t.Fatal(err)
+	}
+
+	if writer, err := zipWriter.CreateHeader(&zip.FileHeader{Name: "big.bin", Method: zip.Store}); err == nil {
+		big := make([]byte, 4*1024*1024)
+		rand.New(rand.NewSource(0)).Read(big)
+		writer.Write(big)
+	} else {
+		t.Fatal(err)
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	count *atomic.Int64
+}
+
+// ([io.Writer] interface)
+func (self *countingResponseWriter) Write(p []byte) (int, error) {
+	self.count.Add(int64(len(p)))
+	return self.ResponseWriter.Write(p)
+}
@@ -0,0 +1,605 @@
+package exturl
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	contextpkg "context"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/tliron/commonlog"
+	"github.com/tliron/kutil/util"
+)
+
+// Minimum distance between [TarCheckpoint] entries in the uncompressed stream.
+var TarCheckpointInterval int64 = 1024 * 1024
+
+//
+// TarIndex
+//
+
+// The entries of a tarball with the offsets of their data, allowing for direct
+// access to entries without scanning the tarball.
+//
+// Uncompressed tarball entries are read directly at their offsets.
+//
+// eStargz tarballs (gzip tarballs with a table of contents, as used for lazily
+// pulled container image layers) are indexed by reading only the TOC, and their
+// entries are read directly at their compressed offsets. Hardlinks in eStargz
+// tarballs appear as copies of their targets.
+//
+// BGZF tarballs (gzip tarballs made of many small gzip members) are indexed by
+// scanning them, and decompression of entries starts at the nearest
+// [TarCheckpoint].
+//
+// Other compressed tarballs cannot be indexed by NewTarIndex, because
+// decompression of their entries would have to start from the beginning of the
+// tarball. Neither can BGZF tarballs that are read via [RangeReaderAt], because
+// indexing them would require fetching the entire archive via many range
+// requests. When such tarballs are opened via [TarballURL], they are instead
+// decompressed once into a temporary file (counted against the quota, see
+// [Context.SetQuota]), which is then indexed as an uncompressed tarball.
+//
+// Indexes are cached per [Context] (see [TarballURL.Open]) and are safe for
+// concurrent use.
+type TarIndex struct {
+	// The detected archive format
+	ArchiveFormat string
+
+	// Fixed entry path (see [util.FixTarballEntryPath]) to entry
+	Entries map[string]*TarIndexEntry
+
+	// Dir path (without trailing "/", and an empty string for the root) to the
+	// paths of its direct children (with a trailing "/" for dirs)
+	Dirs map[string][]string
+
+	// Sorted by Offset. Only for BGZF tarballs.
+	Checkpoints []TarCheckpoint
+
+	reader ReaderAtCloser
+	size   int64
+	toc    *estargz.Reader // for eStargz tarballs
+}
+
+type TarIndexEntry struct {
+	Header *tar.Header
+	Offset int64 // of the data in the uncompressed stream; -1 for eStargz tarballs
+}
+
+// A point at which decompression can be resumed.
+type TarCheckpoint struct {
+	Offset           int64 // in the uncompressed stream
+	CompressedOffset int64
+}
+
+// Scans the entire tarball. Takes ownership of "reader" and will close it on
+// error.
+//
+// Returns a [NotImplemented] error if the tarball cannot be indexed (see
+// [TarIndex]).
+//
+// "archiveFormat" is used if the format cannot be detected (see
+// [DetectTarballArchiveFormat]).
+func NewTarIndex(reader ReaderAtCloser, size int64, archiveFormat string) (*TarIndex, error) {
+	if tarIndex, err := newTarIndex(reader, size, archiveFormat); err == nil {
+		return tarIndex, nil
+	} else {
+		reader.Close()
+		return nil, err
+	}
+}
+
+func newTarIndex(reader ReaderAtCloser, size int64, archiveFormat string) (*TarIndex, error) {
+	header := make([]byte, min(size, TARBALL_HEADER_SIZE))
+	if _, err := reader.ReadAt(header, 0); (err != nil) && (err != io.EOF) {
+		return nil, err
+	}
+
+	if archiveFormat_ := DetectTarballArchiveFormat(header); archiveFormat_ != "" {
+		archiveFormat = archiveFormat_
+	} else if !IsValidTarballArchiveFormat(archiveFormat) {
+		return nil, NewNotImplementedf("unsupported tarball archive format: %q", archiveFormat)
+	}
+
+	self := TarIndex{
+		ArchiveFormat: archiveFormat,
+		Entries:       make(map[string]*TarIndexEntry),
+		reader:        reader,
+		size:          size,
+	}
+
+	section := io.NewSectionReader(reader, 0, size)
+
+	if archiveFormat == "tar.gz" {
+		if toc, err := estargz.Open(section); err == nil {
+			self.indexTOC(toc)
+			return &self, nil
+		}
+	}
+
+	if _, ok := reader.(*RangeReaderAt); ok && (archiveFormat != "tar") {
+		return nil, NewNotImplementedf("cannot index a remote compressed tarball without a TOC")
+	}
+
+	var stream interface {
+		io.Reader
+		position() int64
+	}
+
+	switch archiveFormat {
+	case "tar":
+		// tar.Reader will seek over entry data
+		stream = &seekingPositionReader{positionReader{reader: section, seeker: section}}
+
+	case "tar.gz":
+		if gzipReader, err := newGzipMembersReader(section, &self.Checkpoints); err == nil {
+			if !isBGZF(gzipReader.gzipReader.Header.Extra) {
+				return nil, NewNotImplementedf("cannot index a gzip tarball that is neither eStargz nor BGZF")
+			}
+			stream = &positionReader{reader: gzipReader}
+		} else {
+			return nil, err
+		}
+
+	default:
+		return nil, NewNotImplementedf("cannot index a %s tarball", archiveFormat)
+	}
+
+	tarReader := tar.NewReader(stream)
+	var names []string
+	for {
+		if header, err := tarReader.Next(); err == nil {
+			path := util.FixTarballEntryPath(header.Name)
+			// Like util.TarballReader, the first entry wins
+			if _, ok := self.Entries[path]; !ok {
+				self.Entries[path] = &TarIndexEntry{header, stream.position()}
+				names = append(names, header.Name)
+			}
+		} else if err == io.EOF {
+			break
+		} else {
+			return nil, err
+		}
+	}
+
+	self.Dirs = getArchiveDirs(names)
+
+	return &self, nil
+}
+
+func (self *TarIndex) indexTOC(toc *estargz.Reader) {
+	self.toc = toc
+
+	var names []string
+	var index func(dir string, entry *estargz.TOCEntry)
+	index = func(dir string, entry *estargz.TOCEntry) {
+		entry.ForeachChild(func(baseName string, child *estargz.TOCEntry) bool {
+			path := baseName
+			if dir != "" {
+				path = dir + "/" + baseName
+			}
+
+			if child.Type == "dir" {
+				self.Entries[path+"/"] = &TarIndexEntry{newTOCEntryHeader(path+"/", child), -1}
+				names = append(names, path+"/")
+				index(path, child)
+			} else {
+				self.Entries[path] = &TarIndexEntry{newTOCEntryHeader(path, child), -1}
+				names = append(names, path)
+			}
+
+			return true
+		})
+	}
+
+	if root, ok := toc.Lookup(""); ok {
+		index("", root)
+	}
+
+	self.Dirs = getArchiveDirs(names)
+}
+
+// ([io.Closer] interface)
+func (self *TarIndex) Close() error {
+	return self.reader.Close()
+}
+
+// ([archiveLinkFunc] signature)
+func (self *TarIndex) getLink(path string) (string, bool, bool, error) {
+	if entry, ok := self.Entries[path]; ok {
+		return getTarHeaderLink(entry.Header)
+	}
+	return "", false, false, nil
+}
+
+// Returns nil if not found.
+func (self *TarIndex) stat(path string) fs.FileInfo {
+	if entry, ok := self.Entries[path]; ok {
+		return entry.Header.FileInfo()
+	} else if entry, ok := self.Entries[strings.TrimSuffix(path, "/")+"/"]; ok {
+		return entry.Header.FileInfo()
+	} else if _, ok := self.Dirs[strings.Trim(path, "/")]; ok {
+		return archiveDirInfo{path}
+	}
+	return nil
+}
+
+// Returns true if entries are opened with support for random access (see
+// [RandomAccessURL]).
+func (self *TarIndex) IsRandomAccess() bool {
+	return (self.ArchiveFormat == "tar") || (self.toc != nil)
+}
+
+// Returns nil if not found.
+func (self *TarIndex) Open(path string) (io.ReadCloser, error) {
+	if entry, ok := self.Entries[path]; ok {
+		if self.toc != nil {
+			if entry.Header.Typeflag == tar.TypeReg {
+				if reader, err := self.toc.OpenFile(path); err == nil {
+					return &sectionReader{reader}, nil
+				} else {
+					return nil, err
+				}
+			} else {
+				return &sectionReader{io.NewSectionReader(self.reader, 0, 0)}, nil
+			}
+		}
+
+		if self.ArchiveFormat == "tar" {
+			return &sectionReader{io.NewSectionReader(self.reader, entry.Offset, entry.Header.Size)}, nil
+		}
+
+		var checkpoint TarCheckpoint
+		for _, checkpoint_ := range self.Checkpoints {
+			if checkpoint_.Offset > entry.Offset {
+				break
+			}
+			checkpoint = checkpoint_
+		}
+
+		compressed := io.NewSectionReader(self.reader, checkpoint.CompressedOffset, self.size-checkpoint.CompressedOffset)
+		if decompressor, err := newTarballDecompressor(self.ArchiveFormat, compressed); err == nil {
+			if _, err := io.CopyN(io.Discard, decompressor, entry.Offset-checkpoint.Offset); err == nil {
+				return &tarIndexEntryReader{io.LimitReader(decompressor, entry.Header.Size), decompressor}, nil
+			} else {
+				decompressor.Close()
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns nil if the tarball cannot be indexed, i.e. if it cannot be decompressed
+// into a temporary file because the quota would be exceeded.
+//
+// Shared by all callers for the same archive key until [Context.Release].
+func (self *Context) getTarIndex(context contextpkg.Context, tarballUrl *TarballURL) (*TarIndex, error) {
+	key := tarballUrl.ArchiveURL.Key()
+
+	self.lock.Lock()
+	tarIndex, ok := self.tarIndexes[key]
+	releases := self.releases
+	self.lock.Unlock()
+
+	if ok {
+		if tarIndex != nil {
+			self.cacheLookup(tarballUrl.ArchiveURL, true, CacheKindTarIndex)
+		}
+		return tarIndex, nil
+	}
+
+	var openRandomAccess func(context contextpkg.Context) (ReaderAtCloser, int64, error)
+	switch archiveUrl := tarballUrl.ArchiveURL.(type) {
+	case compressedRandomAccessURL:
+		openRandomAccess = archiveUrl.openCompressedRandomAccess
+	case RandomAccessURL:
+		openRandomAccess = archiveUrl.OpenRandomAccess
+	}
+
+	self.cacheLookup(tarballUrl.ArchiveURL, false, CacheKindTarIndex)
+
+	if tarIndex, err := self.tarIndexFlights.Do(context, key, func(context contextpkg.Context) (any, error) {
+		self.lock.Lock()
+		tarIndex, ok := self.tarIndexes[key]
+		self.lock.Unlock()
+
+		if ok {
+			return tarIndex, nil
+		}
+
+		// The reader outlives this call, so it must not be cancelled with it
+		context = nestedOpen(contextpkg.WithoutCancel(context))
+
+		if openRandomAccess != nil {
+			if reader, size, err := openRandomAccess(context); err == nil {
+				if tarIndex, err = NewTarIndex(reader, size, tarballUrl.ArchiveFormat); (err != nil) && !IsNotImplemented(err) {
+					return nil, err
+				}
+			} else if !IsNotImplemented(err) {
+				return nil, err
+			}
+		}
+
+		if tarIndex == nil {
+			var err error
+			if tarIndex, err = self.newDecompressedTarIndex(context, tarballUrl); (err != nil) && !IsQuotaExceeded(err) {
+				return nil, err
+			}
+		}
+
+		// A nil index is also stored, so that we will not try again
+		self.lock.Lock()
+		if self.releases != releases {
+			// Release will not close it
+			self.lock.Unlock()
+			if tarIndex != nil {
+				tarIndex.Close()
+			}
+			return nil, newReleasedError(key)
+		}
+		if self.tarIndexes == nil {
+			self.tarIndexes = make(map[string]*TarIndex)
+		}
+		self.tarIndexes[key] = tarIndex
+		self.lock.Unlock()
+
+		return tarIndex, nil
+	}); err == nil {
+		return tarIndex.(*TarIndex), nil
+	} else {
+		return nil, err
+	}
+}
+
+// Decompresses the tarball once into a temporary file, which is then indexed as an
+// uncompressed tarball. For tarballs that cannot be indexed otherwise.
+//
+// The file is deleted by [Context.Release].
+func (self *Context) newDecompressedTarIndex(context contextpkg.Context, tarballUrl *TarballURL) (*TarIndex, error) {
+	if reader, _, err := tarballUrl.openDecompressed(context); err == nil {
+		defer commonlog.CallAndLogWarning(reader.Close, "exturl.newDecompressedTarIndex", log)
+
+		// Not the key of any URL
+		key := "decompressed:" + tarballUrl.ArchiveURL.Key()
+		if entry, err := self.addTemporaryFile(key, reader); err == nil {
+			if file, err := os.Open(entry.path); err == nil {
+				return NewTarIndex(file, entry.size, "tar")
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Implemented by URLs that open decompressed content, such as the first layer
+// of a [DockerURL], for random access to the compressed archive.
+type compressedRandomAccessURL interface {
+	openCompressedRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error)
+}
+
+// ([archiveLinkFunc] signature)
+func getTarHeaderLink(header *tar.Header) (string, bool, bool, error) {
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		return header.Linkname, false, true, nil
+	case tar.TypeLink:
+		return header.Linkname, true, true, nil
+	default:
+		return "", false, false, nil
+	}
+}
+
+func newTOCEntryHeader(name string, entry *estargz.TOCEntry) *tar.Header {
+	header := tar.Header{
+		Name:     name,
+		Linkname: entry.LinkName,
+		Mode:     entry.Mode,
+		Uid:      entry.UID,
+		Gid:      entry.GID,
+		Uname:    entry.Uname,
+		Gname:    entry.Gname,
+		ModTime:  entry.ModTime(),
+		Devmajor: int64(entry.DevMajor),
+		Devminor: int64(entry.DevMinor),
+	}
+
+	switch entry.Type {
+	case "dir":
+		header.Typeflag = tar.TypeDir
+	case "reg":
+		header.Typeflag = tar.TypeReg
+		header.Size = entry.Size
+	case "symlink":
+		header.Typeflag = tar.TypeSymlink
+	case "hardlink":
+		header.Typeflag = tar.TypeLink
+	case "char":
+		header.Typeflag = tar.TypeChar
+	case "block":
+		header.Typeflag = tar.TypeBlock
+	case "fifo":
+		header.Typeflag = tar.TypeFifo
+	}
+
+	return &header
+}
+
+//
+// positionReader
+//
+
+type positionReader struct {
+	reader    io.Reader
+	seeker    io.Seeker // can be nil
+	position_ int64
+}
+
+// ([io.Reader] interface)
+func (self *positionReader) Read(p []byte) (int, error) {
+	count, err := self.reader.Read(p)
+	self.position_ += int64(count)
+	return count, err
+}
+
+func (self *positionReader) position() int64 {
+	return self.position_
+}
+
+//
+// seekingPositionReader
+//
+
+// tar.Reader will only seek if the reader is an [io.Seeker]
+type seekingPositionReader struct {
+	positionReader
+}
+
+// ([io.Seeker] interface)
+func (self *seekingPositionReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := self.seeker.Seek(offset, whence)
+	if err == nil {
+		self.position_ = position
+	}
+	return position, err
+}
+
+//
+// gzipMembersReader
+//
+
+// Reads all gzip members (like gzip.Reader in multistream mode) while recording
+// a checkpoint at the start of members.
+//
+// Decompression can only be resumed at member boundaries, because each member
+// is compressed independently.
+type gzipMembersReader struct {
+	compressed  *countingReader
+	gzipReader  *gzip.Reader
+	checkpoints *[]TarCheckpoint
+	position    int64
+}
+
+func newGzipMembersReader(reader io.Reader, checkpoints *[]TarCheckpoint) (*gzipMembersReader, error) {
+	// gzip.Reader will not read past the end of a member if the reader is an
+	// io.ByteReader
+	compressed := &countingReader{reader: bufio.NewReader(reader)}
+	*checkpoints = []TarCheckpoint{{0, 0}}
+	if gzipReader, err := gzip.NewReader(compressed); err == nil {
+		gzipReader.Multistream(false)
+		return &gzipMembersReader{compressed, gzipReader, checkpoints, 0}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// ([io.Reader] interface)
+func (self *gzipMembersReader) Read(p []byte) (int, error) {
+	for {
+		count, err := self.gzipReader.Read(p)
+		self.position += int64(count)
+		if err != io.EOF {
+			return count, err
+		}
+
+		// Next member?
+		if _, err := self.compressed.reader.Peek(1); err == io.EOF {
+			return count, io.EOF
+		} else if err != nil {
+			return count, err
+		}
+
+		checkpoints := *self.checkpoints
+		if self.position-checkpoints[len(checkpoints)-1].Offset >= TarCheckpointInterval {
+			*self.checkpoints = append(checkpoints, TarCheckpoint{self.position, self.compressed.count})
+		}
+
+		if err := self.gzipReader.Reset(self.compressed); err != nil {
+			return count, err
+		}
+		self.gzipReader.Multistream(false)
+
+		if count > 0 {
+			return count, nil
+		}
+	}
+}
+
+// BGZF members have a "BC" subfield in the gzip header's extra field
+func isBGZF(extra []byte) bool {
+	for len(extra) >= 4 {
+		length := 4 + (int(extra[2]) | int(extra[3])<<8)
+		if (extra[0] == 'B') && (extra[1] == 'C') {
+			return true
+		}
+		if length > len(extra) {
+			break
+		}
+		extra = extra[length:]
+	}
+	return false
+}
+
+//
+// countingReader
+//
+
+type countingReader struct {
+	reader *bufio.Reader
+	count  int64
+}
+
+// ([io.Reader] interface)
+func (self *countingReader) Read(p []byte) (int, error) {
+	count, err := self.reader.Read(p)
+	self.count += int64(count)
+	return count, err
+}
+
+// ([io.ByteReader] interface)
+func (self *countingReader) ReadByte() (byte, error) {
+	b, err := self.reader.ReadByte()
+	if err == nil {
+		self.count++
+	}
+	return b, err
+}
+
+//
+// sectionReader
+//
+
+// Supports random access.
+type sectionReader struct {
+	*io.SectionReader
+}
+
+// ([io.Closer] interface)
+func (self *sectionReader) Close() error {
+	return nil
+}
+
+//
+// tarIndexEntryReader
+//
+
+type tarIndexEntryReader struct {
+	io.Reader
+	decompressor io.Closer
+}
+
+// ([io.Closer] interface)
+func (self *tarIndexEntryReader) Close() error {
+	return self.decompressor.Close()
+}
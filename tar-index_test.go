@@ -0,0 +1,169 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	contextpkg "context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestTarIndex(t *testing.T) {
+	content := testTarball(t, "tar")
+
+	for _, archiveFormat := range []string{"tar", "tar.gz"} {
+		t.Run(archiveFormat, func(t *testing.T) {
+			compressed := content
+			if archiveFormat == "tar.gz" {
+				compressed = testBGZF(t, content, 16*1024)
+			}
+
+			tarIndex, err := exturl.NewTarIndex(newBytesReader(compressed), int64(len(compressed)), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tarIndex.Close()
+
+			if tarIndex.ArchiveFormat != archiveFormat {
+				t.Errorf("format: %s", tarIndex.ArchiveFormat)
+			}
+
+			if archiveFormat == "tar.gz" {
+				if len(tarIndex.Checkpoints) < 2 {
+					t.Errorf("checkpoints: %d", len(tarIndex.Checkpoints))
+				}
+			}
+
+			for index := range 10 {
+				path := fmt.Sprintf("dir/file%d.txt", index)
+				if reader, err := tarIndex.Open(path); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				} else if reader == nil {
+					t.Errorf("%s: not found", path)
+				} else {
+					b, err := io.ReadAll(reader)
+					reader.Close()
+					if err != nil {
+						t.Errorf("%s: %s", path, err.Error())
+					} else if !bytes.Equal(b, testTarballEntry(index)) {
+						t.Errorf("%s: wrong content", path)
+					}
+				}
+			}
+
+			if reader, _ := tarIndex.Open("missing.txt"); reader != nil {
+				t.Errorf("missing found")
+			}
+
+			if children := tarIndex.Dirs["dir"]; len(children) != 10 {
+				t.Errorf("dir: %v", children)
+			}
+		})
+	}
+}
+
+func TestTarIndexNotImplemented(t *testing.T) {
+	content := testTarball(t, "tar.gz")
+
+	// A single gzip member would have to be decompressed from the start anyway
+	if _, err := exturl.NewTarIndex(newBytesReader(content), int64(len(content)), ""); !exturl.IsNotImplemented(err) {
+		t.Errorf("not NotImplemented: %v", err)
+	}
+
+	if _, err := exturl.NewTarIndex(newBytesReader([]byte("not a tarball")), 13, "tar.rar"); !exturl.IsNotImplemented(err) {
+		t.Errorf("unsupported format not NotImplemented: %v", err)
+	}
+}
+
+func testTarball(t *testing.T, archiveFormat string) []byte {
+	var entries []exturltest.TarballEntry
+	for index := range 10 {
+		entries = append(entries, exturltest.TarballFile(fmt.Sprintf("dir/file%d.txt", index), testTarballEntry(index)))
+	}
+	return exturltest.TarballOf(t, archiveFormat, entries...)
+}
+
+func testTarballEntry(index int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("%d", index)), 10000+index*1000)
+}
+
+func testBGZF(t *testing.T, content []byte, memberSize int) []byte {
+	saved := exturl.TarCheckpointInterval
+	exturl.TarCheckpointInterval = int64(memberSize)
+	t.Cleanup(func() {
+		exturl.TarCheckpointInterval = saved
+	})
+
+	var buffer bytes.Buffer
+	for start := 0; start < len(content); start += memberSize {
+		var member bytes.Buffer
+		gzipWriter := gzip.NewWriter(&member)
+		gzipWriter.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		if _, err := gzipWriter.Write(content[start:min(start+memberSize, len(content))]); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzipWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// BSIZE is the member size minus 1, after the 12-byte header and the
+		// 4-byte subfield header
+		binary.LittleEndian.PutUint16(member.Bytes()[16:], uint16(member.Len()-1))
+		buffer.Write(member.Bytes())
+	}
+	return buffer.Bytes()
+}
+
+// An [exturl.ReaderAtCloser] for in-memory content
+type bytesReader struct {
+	*bytes.Reader
+}
+
+func newBytesReader(content []byte) *bytesReader {
+	return &bytesReader{bytes.NewReader(content)}
+}
+
+// ([io.Closer] interface)
+func (self *bytesReader) Close() error {
+	return nil
+}
+
+func TestTarIndexDecompressed(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	dir := t.TempDir()
+	urlContext.SetTemporaryDir(dir)
+
+	archiveUrl := urlContext.NewFileURL(writeFile(t, "test.tar.gz", testTarball(t, "tar.gz")))
+	for index := range 10 {
+		url := exturl.NewTarballURL(fmt.Sprintf("dir/file%d.txt", index), archiveUrl, "tar.gz")
+		if b, err := exturl.ReadBytes(contextpkg.TODO(), url); err != nil {
+			t.Errorf("%s: %s", url, err.Error())
+		} else if !bytes.Equal(b, testTarballEntry(index)) {
+			t.Errorf("%s: wrong content", url)
+		}
+	}
+
+	// Decompressed once, so entries support random access
+	if reader, _, err := exturl.NewTarballURL("dir/file0.txt", archiveUrl, "tar.gz").OpenRandomAccess(contextpkg.TODO()); err == nil {
+		reader.Close()
+	} else {
+		t.Errorf("random access: %s", err.Error())
+	}
+
+	// The decompressed tarball and its pid file
+	if dirEntries, err := os.ReadDir(dir); err != nil {
+		t.Errorf("temporary dir: %s", err.Error())
+	} else if len(dirEntries) != 2 {
+		t.Errorf("temporary files: %d", len(dirEntries))
+	}
+}
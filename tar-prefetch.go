@@ -0,0 +1,269 @@
+package exturl
+
+import (
+	"archive/tar"
+	contextpkg "context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tliron/kutil/util"
+)
+
+// Prefetched entries larger than this are stored in temporary files rather than
+// in memory.
+var TarPrefetchMemoryThreshold int64 = 1024 * 1024
+
+// Maximum total size of prefetched entries stored in memory per [Context]. Once
+// reached, further entries are stored in temporary files.
+var TarPrefetchMaxMemory int64 = 64 * 1024 * 1024
+
+// The reader is only valid during the call.
+type TarballEntryFunc func(tarballUrl *TarballURL, header *tar.Header, reader io.Reader) error
+
+// Reads the regular file entries of a tarball in a single pass, calling "read"
+// for each in the order in which they appear in the archive.
+//
+// "paths" are entry paths. A path ending in "/" selects all entries under that
+// dir, and an empty path selects all entries. The pass stops as soon as all
+// paths (without a trailing "/") have been read. Returns a [NotFound] error if
+// any of these are not in the tarball, though "read" will have been called for
+// those that are.
+//
+// This is much more efficient than opening many [TarballURL] for the same
+// compressed tarball, each of which must stream and decompress the tarball up
+// to its entry. See also [PrefetchTarballEntries].
+func ReadTarballEntries(context contextpkg.Context, archiveUrl URL, paths []string, read TarballEntryFunc) error {
+	selection := newTarballEntrySelection(paths)
+	archive := NewTarballURL("", archiveUrl, "")
+
+	if tarballReader, err := archive.OpenArchive(context); err == nil {
+		defer tarballReader.Close()
+
+		for !selection.done() {
+			if header, err := tarballReader.TarReader.Next(); err == nil {
+				if header.Typeflag != tar.TypeReg {
+					continue
+				}
+
+				path := util.FixTarballEntryPath(header.Name)
+				if selection.take(path) {
+					if err := read(archive.Relative(path).(*TarballURL), header, tarballReader.TarReader); err != nil {
+						return err
+					}
+				}
+			} else if err == io.EOF {
+				break
+			} else {
+				return err
+			}
+		}
+
+		if missing := selection.missing(); len(missing) > 0 {
+			return &NotFound{newURLError(archive.Key(), nil, "paths %q not found in tarball: %s", missing, archiveUrl.String())}
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Reads the regular file entries of a tarball in a single pass (see
+// [ReadTarballEntries]) and keeps their content, so that subsequent calls to
+// [TarballURL.Open] for them on the archive URL's [Context] will not have to
+// read the tarball again.
+//
+// Entries up to [TarPrefetchMemoryThreshold] in size are kept in memory (up to
+// [TarPrefetchMaxMemory] in total), larger ones in temporary files. Both count
+// against the quota (see [Context.SetQuota]). They are kept until
+// [Context.Release].
+func PrefetchTarballEntries(context contextpkg.Context, archiveUrl URL, paths []string) error {
+	urlContext := archiveUrl.Context()
+
+	return ReadTarballEntries(context, archiveUrl, paths, func(tarballUrl *TarballURL, header *tar.Header, reader io.Reader) error {
+		key := tarballUrl.Key()
+
+		if header.Size <= TarPrefetchMemoryThreshold {
+			if ok, err := urlContext.reservePrefetched(header.Size); err == nil {
+				if ok {
+					if content, err := io.ReadAll(reader); err == nil {
+						urlContext.addPrefetched(key, content, header.Size)
+						return nil
+					} else {
+						urlContext.freePrefetched(header.Size)
+						return err
+					}
+				}
+			} else {
+				return err
+			}
+		}
+
+		_, err := urlContext.addTemporaryFile(key, reader)
+		return err
+	})
+}
+
+// Returns nil if not prefetched.
+func (self *Context) openPrefetched(tarballUrl *TarballURL) (io.ReadCloser, error) {
+	key := tarballUrl.Key()
+
+	self.lock.Lock()
+	content, ok := self.prefetched[key]
+	self.lock.Unlock()
+
+	if ok {
+		self.cacheLookup(tarballUrl, true, CacheKindTarEntry)
+		return newBytesReader(content), nil
+	}
+
+	if entry, err := self.getTemporaryEntry(key, false); err == nil {
+		if entry != nil {
+			self.cacheLookup(tarballUrl, true, CacheKindTarEntry)
+			return os.Open(entry.path)
+		}
+	} else {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Writes the content to a temporary file that will be deleted by
+// [Context.Release].
+func (self *Context) addTemporaryFile(key string, reader io.Reader) (*temporaryEntry, error) {
+	quota := self.getQuota()
+	if file, size, err := writeTemporaryFile(self.getCleanTemporaryDir(), GetTemporaryPathPattern(key), quota, func(path string, writer io.Writer) (int64, error) {
+		return io.Copy(writer, reader)
+	}); err == nil {
+		file.Close()
+		return self.addTemporaryEntry(key, newTemporaryEntry(key, file.Name(), false, size, quota)), nil
+	} else {
+		return nil, err
+	}
+}
+
+// Returns false if the size would exceed [TarPrefetchMaxMemory].
+func (self *Context) reservePrefetched(size int64) (bool, error) {
+	self.lock.Lock()
+	if self.prefetchedSize+size > TarPrefetchMaxMemory {
+		self.lock.Unlock()
+		return false, nil
+	}
+	self.prefetchedSize += size
+	self.lock.Unlock()
+
+	if quota := self.getQuota(); quota != nil {
+		if err := quota.reserve(size); err != nil {
+			self.lock.Lock()
+			self.prefetchedSize -= size
+			self.lock.Unlock()
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (self *Context) freePrefetched(size int64) {
+	self.lock.Lock()
+	self.prefetchedSize -= size
+	self.lock.Unlock()
+
+	if quota := self.getQuota(); quota != nil {
+		quota.free(size)
+	}
+}
+
+// "size" must have been reserved via [Context.reservePrefetched].
+func (self *Context) addPrefetched(key string, content []byte, size int64) {
+	self.lock.Lock()
+	existing, ok := self.prefetched[key]
+	if self.prefetched == nil {
+		self.prefetched = make(map[string][]byte)
+	}
+	self.prefetched[key] = content
+	self.lock.Unlock()
+
+	if ok {
+		// Prefetched again
+		self.freePrefetched(int64(len(existing)))
+	}
+}
+
+//
+// tarballEntrySelection
+//
+
+type tarballEntrySelection struct {
+	paths    map[string]struct{}
+	prefixes []string
+	all      bool
+	taken    map[string]struct{}
+	left     int // paths not yet taken
+}
+
+func newTarballEntrySelection(paths []string) *tarballEntrySelection {
+	self := tarballEntrySelection{
+		paths: make(map[string]struct{}),
+		taken: make(map[string]struct{}),
+	}
+
+	for _, path := range paths {
+		path = strings.TrimLeft(path, "/")
+		if path == "" {
+			self.all = true
+		} else if strings.HasSuffix(path, "/") {
+			self.prefixes = append(self.prefixes, path)
+		} else {
+			self.paths[path] = struct{}{}
+		}
+	}
+
+	self.left = len(self.paths)
+	return &self
+}
+
+// Returns true if the path is selected and was not already taken.
+func (self *tarballEntrySelection) take(path string) bool {
+	if _, ok := self.taken[path]; ok {
+		// Like util.TarballReader, the first entry wins
+		return false
+	}
+
+	if _, ok := self.paths[path]; ok {
+		self.left--
+	} else if !self.all && !self.hasPrefix(path) {
+		return false
+	}
+
+	self.taken[path] = struct{}{}
+	return true
+}
+
+func (self *tarballEntrySelection) hasPrefix(path string) bool {
+	for _, prefix := range self.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *tarballEntrySelection) done() bool {
+	return (self.left == 0) && !self.all && (len(self.prefixes) == 0)
+}
+
+// Sorted
+func (self *tarballEntrySelection) missing() []string {
+	var missing []string
+	for path := range self.paths {
+		if _, ok := self.taken[path]; !ok {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,160 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"archive/tar"
+	contextpkg "context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestReadTarballEntries(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	archiveUrl := urlContext.NewFileURL(writeFile(t, "many.tar.gz", manyTarball(t, 40)))
+
+	var paths []string
+	if err := exturl.ReadTarballEntries(contextpkg.TODO(), archiveUrl, []string{"dir/file3.txt", "/dir/file1.txt"}, func(tarballUrl *exturl.TarballURL, header *tar.Header, reader io.Reader) error {
+		if content, err := io.ReadAll(reader); err == nil {
+			if string(content) != manyTarballEntry(tarballUrl.Path) {
+				t.Errorf("%s: %q", tarballUrl.Path, content)
+			}
+		} else {
+			return err
+		}
+		paths = append(paths, tarballUrl.Path)
+		return nil
+	}); err != nil {
+		t.Errorf("read: %s", err.Error())
+	}
+
+	// In archive order
+	if fmt.Sprintf("%v", paths) != "[dir/file1.txt dir/file3.txt]" {
+		t.Errorf("paths: %v", paths)
+	}
+
+	var count int
+	if err := exturl.ReadTarballEntries(contextpkg.TODO(), archiveUrl, []string{"dir/", "missing.txt"}, func(tarballUrl *exturl.TarballURL, header *tar.Header, reader io.Reader) error {
+		count++
+		return nil
+	}); !exturl.IsNotFound(err) {
+		t.Errorf("not NotFound: %v", err)
+	}
+
+	if count != 40 {
+		t.Errorf("count: %d", count)
+	}
+}
+
+func TestPrefetchTarballEntries(t *testing.T) {
+	content := manyTarball(t, 40)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// Ignores ranges, so the tarball will be streamed
+		requests.Add(1)
+		writer.Write(content)
+	}))
+	defer server.Close()
+
+	for _, threshold := range []int64{exturl.TarPrefetchMemoryThreshold, 0} {
+		t.Run(fmt.Sprintf("threshold=%d", threshold), func(t *testing.T) {
+			saved := exturl.TarPrefetchMemoryThreshold
+			exturl.TarPrefetchMemoryThreshold = threshold
+			defer func() {
+				exturl.TarPrefetchMemoryThreshold = saved
+			}()
+
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			requests.Store(0)
+			archiveUrl := newURL(t, urlContext, server.URL+"/many.tar.gz")
+			if err := exturl.PrefetchTarballEntries(contextpkg.TODO(), archiveUrl, []string{"dir/"}); err != nil {
+				t.Fatalf("prefetch: %s", err.Error())
+			}
+
+			for index := range 40 {
+				path := fmt.Sprintf("dir/file%d.txt", index)
+				url := newURL(t, urlContext, "tar:"+server.URL+"/many.tar.gz!"+path)
+				if content, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				} else if content != manyTarballEntry(path) {
+					t.Errorf("%s: %q", path, content)
+				}
+			}
+
+			if requests := requests.Load(); requests != 1 {
+				t.Errorf("requests: %d", requests)
+			}
+		})
+	}
+}
+
+func TestPrefetchTarballEntriesMemory(t *testing.T) {
+	saved := exturl.TarPrefetchMaxMemory
+	exturl.TarPrefetchMaxMemory = 100
+	defer func() {
+		exturl.TarPrefetchMaxMemory = saved
+	}()
+
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	dir := t.TempDir()
+	urlContext.SetTemporaryDir(dir)
+
+	archiveUrl := urlContext.NewFileURL(writeFile(t, "many.tar.gz", manyTarball(t, 40)))
+	if err := exturl.PrefetchTarballEntries(contextpkg.TODO(), archiveUrl, []string{""}); err != nil {
+		t.Fatalf("prefetch: %s", err.Error())
+	}
+
+	// Entries beyond the memory limit are in temporary files
+	if dirEntries, err := os.ReadDir(dir); err != nil {
+		t.Errorf("temporary dir: %s", err.Error())
+	} else if len(dirEntries) == 0 {
+		t.Errorf("no temporary files")
+	}
+
+	for index := range 40 {
+		path := fmt.Sprintf("dir/file%d.txt", index)
+		url := newURL(t, urlContext, "tar:"+archiveUrl.String()+"!"+path)
+		if content, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+			t.Errorf("%s: %s", path, err.Error())
+		} else if content != manyTarballEntry(path) {
+			t.Errorf("%s: %q", path, content)
+		}
+	}
+
+	// Entries in memory count against the quota
+	urlContext = exturl.NewContext()
+	defer urlContext.Release()
+	urlContext.SetQuota(50)
+
+	if err := exturl.PrefetchTarballEntries(contextpkg.TODO(), urlContext.NewFileURL(archiveUrl.Path), []string{""}); !exturl.IsQuotaExceeded(err) {
+		t.Errorf("not QuotaExceeded: %v", err)
+	}
+}
+
+func manyTarball(t *testing.T, count int) []byte {
+	var entries []exturltest.TarballEntry
+	for index := range count {
+		path := fmt.Sprintf("dir/file%d.txt", index)
+		entries = append(entries, exturltest.TarballFile(path, []byte(manyTarballEntry(path))))
+	}
+	return exturltest.TarballOf(t, "tar.gz", entries...)
+}
+
+func manyTarballEntry(path string) string {
+	return "content of " + path + "\n"
+}
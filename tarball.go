@@ -2,21 +2,30 @@ package exturl
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	contextpkg "context"
 	"fmt"
 	"io"
+	"io/fs"
 	pathpkg "path"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/pgzip"
+	"github.com/pierrec/lz4/v4"
 	"github.com/tliron/kutil/util"
+	"github.com/ulikunitz/xz"
 )
 
 // Note: we must use the "path" package rather than "filepath" to ensure consistency with Windows
 
-// TODO: xz support, consider: https://github.com/ulikunitz/xz
+var TARBALL_ARCHIVE_FORMATS = []string{"tar", "tar.gz", "tar.xz", "tar.zst", "tar.bz2", "tar.lz4"}
 
-var TARBALL_ARCHIVE_FORMATS = []string{"tar", "tar.gz"}
+// Enough bytes to detect all [TARBALL_ARCHIVE_FORMATS]. An uncompressed tarball
+// has its "ustar" magic at offset 257.
+const TARBALL_HEADER_SIZE = 512
 
 func IsValidTarballArchiveFormat(archiveFormat string) bool {
 	for _, archiveFormat_ := range TARBALL_ARCHIVE_FORMATS {
@@ -56,22 +65,39 @@ func NewTarballURL(path string, archiveUrl URL, archiveFormat string) *TarballUR
 
 func NewValidTarballURL(context contextpkg.Context, path string, archiveUrl URL, archiveFormat string) (*TarballURL, error) {
 	self := NewTarballURL(path, archiveUrl, archiveFormat)
-	if tarballReader, err := self.OpenArchive(context); err == nil {
-		defer tarballReader.Close()
+	return self.validate(context)
+}
 
-		for {
-			if header, err := tarballReader.TarReader.Next(); err == nil {
-				if self.Path == util.FixTarballEntryPath(header.Name) {
+func (self *TarballURL) validate(context contextpkg.Context) (*TarballURL, error) {
+	if tarIndex, err := self.Context().getTarIndex(context, self); err == nil {
+		if tarIndex != nil {
+			if path, err := resolveArchivePath(self.Key(), self.Path, true, tarIndex.getLink); err == nil {
+				if _, ok := tarIndex.Entries[path]; ok {
 					return self, nil
+				} else {
+					return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in tarball: %s", self.Path, self.ArchiveURL.String())}
 				}
-			} else if err == io.EOF {
-				break
 			} else {
 				return nil, err
 			}
 		}
+	} else {
+		return nil, err
+	}
 
-		return nil, NewNotFoundf("path %q not found in tarball: %s", path, archiveUrl.String())
+	if tarballReader, path, err := self.findEntry(context); err == nil {
+		if tarballReader != nil {
+			tarballReader.Close()
+			return self, nil
+		} else if path != self.Path {
+			if _, err := self.relink(path).validate(context); err == nil {
+				return self, nil
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in tarball: %s", self.Path, self.ArchiveURL.String())}
+		}
 	} else {
 		return nil, err
 	}
@@ -107,10 +133,7 @@ func (self *TarballURL) Format() string {
 
 // ([URL] interface)
 func (self *TarballURL) Base() URL {
-	path := pathpkg.Dir(self.Path)
-	if path != "/" {
-		path += "/"
-	}
+	path := getArchiveBasePath(self.Path)
 
 	return &TarballURL{
 		Path:          path,
@@ -130,50 +153,165 @@ func (self *TarballURL) Relative(path string) URL {
 
 // ([URL] interface)
 func (self *TarballURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
-	tarballUrl := self.Relative(path).(*TarballURL)
-	if tarballReader, err := tarballUrl.OpenArchive(context); err == nil {
-		defer tarballReader.Close()
+	if tarballUrl, err := self.Relative(path).(*TarballURL).validate(context); err == nil {
+		return tarballUrl, nil
+	} else {
+		return nil, err
+	}
+}
 
-		for {
-			if header, err := tarballReader.TarReader.Next(); err == nil {
-				if tarballUrl.Path == util.FixTarballEntryPath(header.Name) {
-					return tarballUrl, nil
+// ([URL] interface)
+func (self *TarballURL) Key() string {
+	return fmt.Sprintf("tar:%s!/%s", self.ArchiveURL.String(), escapeArchivePath(self.Path))
+}
+
+// ([URL] interface)
+func (self *TarballURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.Context().open(context, self, self.open)
+}
+
+func (self *TarballURL) open(context contextpkg.Context) (io.ReadCloser, error) {
+	if reader, err := self.Context().openPrefetched(self); err == nil {
+		if reader != nil {
+			return reader, nil
+		}
+	} else {
+		return nil, err
+	}
+
+	if tarIndex, err := self.Context().getTarIndex(context, self); err == nil {
+		if tarIndex != nil {
+			if path, err := resolveArchivePath(self.Key(), self.Path, true, tarIndex.getLink); err == nil {
+				if path != self.Path {
+					return self.relink(path).open(context)
+				}
+			} else {
+				return nil, err
+			}
+
+			if reader, err := tarIndex.Open(self.Path); err == nil {
+				if reader != nil {
+					return reader, nil
+				} else {
+					return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
 				}
-			} else if err == io.EOF {
-				break
 			} else {
 				return nil, err
 			}
 		}
+	} else {
+		return nil, err
+	}
 
-		return nil, NewNotFoundf("path %q not found in tarball: %s", tarballUrl.Path, tarballUrl.ArchiveURL.String())
+	if tarballReader, path, err := self.findEntry(context); err == nil {
+		if tarballReader != nil {
+			return util.NewTarballEntryReader(tarballReader), nil
+		} else if path != self.Path {
+			return self.relink(path).open(context)
+		} else {
+			return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+		}
 	} else {
 		return nil, err
 	}
 }
 
-// ([URL] interface)
-func (self *TarballURL) Key() string {
-	return fmt.Sprintf("tar:%s!/%s", self.ArchiveURL.String(), self.Path)
-}
+// ([ListableURL] interface)
+func (self *TarballURL) List(context contextpkg.Context) ([]URL, error) {
+	var path string
+	var paths []string
+	var ok bool
 
-// ([URL] interface)
-func (self *TarballURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
-	if tarballReader, err := self.OpenArchive(context); err == nil {
-		if tarballEntryReader, err := tarballReader.Open(self.Path); err == nil {
-			if tarballEntryReader != nil {
-				return tarballEntryReader, nil
+	if tarIndex, err := self.Context().getTarIndex(context, self); err == nil {
+		if tarIndex != nil {
+			if path, err = resolveArchivePath(self.Key(), self.Path, true, tarIndex.getLink); err == nil {
+				paths, ok = tarIndex.Dirs[strings.Trim(path, "/")]
 			} else {
-				tarballReader.Close()
-				return nil, NewNotFoundf("path %q not found in archive: %s", self.Path, self.ArchiveURL.String())
+				return nil, err
+			}
+		} else if tarballReader, err := self.OpenArchive(context); err == nil {
+			defer tarballReader.Close()
+
+			var names []string
+			links := make(map[string]*tar.Header)
+			if err := tarballReader.Iterate(func(header *tar.Header) bool {
+				names = append(names, header.Name)
+				addTarLink(links, header)
+				return true
+			}); err != nil {
+				return nil, err
+			}
+
+			if path, err = resolveArchivePath(self.Key(), self.Path, true, getTarLinksFunc(links)); err == nil {
+				paths, ok = listArchiveEntries(path, names)
+			} else {
+				return nil, err
 			}
 		} else {
-			tarballReader.Close()
 			return nil, err
 		}
 	} else {
 		return nil, err
 	}
+
+	if ok {
+		paths = relinkArchivePaths(paths, self.Path, path)
+		urls := make([]URL, len(paths))
+		for index, path := range paths {
+			urls[index] = &TarballURL{
+				Path:          path,
+				ArchiveURL:    self.ArchiveURL,
+				ArchiveFormat: self.ArchiveFormat,
+			}
+		}
+		return urls, nil
+	} else {
+		return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+	}
+}
+
+// ([StatableURL] interface)
+//
+// If the tarball cannot be indexed (see [TarIndex]) then it is read in its
+// entirety.
+func (self *TarballURL) Stat(context contextpkg.Context) (fs.FileInfo, error) {
+	tarIndex, err := self.Context().getTarIndex(context, self)
+	if err != nil {
+		return nil, err
+	}
+
+	if tarIndex == nil {
+		if tarIndex, err = self.scanArchive(context); err != nil {
+			return nil, err
+		}
+	}
+
+	if path, err := resolveArchivePath(self.Key(), self.Path, false, tarIndex.getLink); err == nil {
+		if info := tarIndex.stat(path); info != nil {
+			return info, nil
+		} else {
+			return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// ([RandomAccessURL] interface)
+//
+// Only entries of indexed, uncompressed tarballs (including compressed tarballs
+// that were decompressed into a temporary file, see [TarIndex]) and of eStargz
+// tarballs support random access.
+func (self *TarballURL) OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error) {
+	if tarIndex, err := self.Context().getTarIndex(context, self); err == nil {
+		if (tarIndex != nil) && tarIndex.IsRandomAccess() {
+			return openRandomAccess(context, self)
+		} else {
+			return nil, 0, &NotImplemented{newURLError(self.Key(), nil, "random access not supported: %s", self.Key())}
+		}
+	} else {
+		return nil, 0, err
+	}
 }
 
 // ([URL] interface)
@@ -181,42 +319,248 @@ func (self *TarballURL) Context() *Context {
 	return self.ArchiveURL.Context()
 }
 
+// The compression is detected from the archive's header, falling back to
+// ArchiveFormat if it cannot be detected. This allows for archive URLs that
+// have no file extension or a misleading one.
 func (self *TarballURL) OpenArchive(context contextpkg.Context) (*util.TarballReader, error) {
-	if !IsValidTarballArchiveFormat(self.ArchiveFormat) {
-		return nil, fmt.Errorf("unsupported tarball archive format: %q", self.ArchiveFormat)
-	}
+	tarballReader, _, err := self.openArchive(context)
+	return tarballReader, err
+}
 
-	if archiveReader, err := self.ArchiveURL.Open(context); err == nil {
-		switch self.ArchiveFormat {
-		case "tar":
-			return util.NewTarballReader(tar.NewReader(archiveReader), archiveReader, nil), nil
+// Returns the archive format detected from the archive's header, which may
+// differ from ArchiveFormat. See [DetectTarballArchiveFormat].
+func (self *TarballURL) DetectArchiveFormat(context contextpkg.Context) (string, error) {
+	if tarballReader, archiveFormat, err := self.openArchive(context); err == nil {
+		tarballReader.Close()
+		return archiveFormat, nil
+	} else {
+		return "", err
+	}
+}
 
-		case "tar.gz":
-			if gzipReader, err := pgzip.NewReader(archiveReader); err == nil {
-				return util.NewTarballReader(tar.NewReader(gzipReader), archiveReader, gzipReader), nil
+// Streams the archive until the entry is found, returning a reader positioned at
+// its data. If the entry is not found, or is a link, returns a nil reader and the
+// path as resolved via the links in the archive (see [resolveArchivePath]), which
+// requires streaming the entire archive.
+func (self *TarballURL) findEntry(context contextpkg.Context) (*util.TarballReader, string, error) {
+	if tarballReader, err := self.OpenArchive(context); err == nil {
+		links := make(map[string]*tar.Header)
+		for {
+			if header, err := tarballReader.TarReader.Next(); err == nil {
+				if !addTarLink(links, header) {
+					// Like util.TarballReader, the first entry wins
+					if _, ok := links[self.Path]; !ok && (self.Path == util.FixTarballEntryPath(header.Name)) {
+						return tarballReader, self.Path, nil
+					}
+				}
+			} else if err == io.EOF {
+				break
 			} else {
-				archiveReader.Close()
-				return nil, err
+				tarballReader.Close()
+				return nil, "", err
 			}
+		}
+		tarballReader.Close()
 
-		default:
-			return nil, fmt.Errorf("unsupported tarball format: %s", self.ArchiveFormat)
+		if path, err := resolveArchivePath(self.Key(), self.Path, true, getTarLinksFunc(links)); err == nil {
+			return nil, path, nil
+		} else {
+			return nil, "", err
 		}
+	} else {
+		return nil, "", err
+	}
+}
+
+// Streams the entire archive into an index that has entries and dirs but
+// cannot open entries. For when the archive cannot be indexed.
+func (self *TarballURL) scanArchive(context contextpkg.Context) (*TarIndex, error) {
+	if tarballReader, err := self.OpenArchive(context); err == nil {
+		defer tarballReader.Close()
+
+		tarIndex := TarIndex{Entries: make(map[string]*TarIndexEntry)}
+		var names []string
+		if err := tarballReader.Iterate(func(header *tar.Header) bool {
+			path := util.FixTarballEntryPath(header.Name)
+			if _, ok := tarIndex.Entries[path]; !ok {
+				tarIndex.Entries[path] = &TarIndexEntry{header, -1}
+				names = append(names, header.Name)
+			}
+			return true
+		}); err != nil {
+			return nil, err
+		}
+
+		tarIndex.Dirs = getArchiveDirs(names)
+		return &tarIndex, nil
 	} else {
 		return nil, err
 	}
 }
 
+// A URL for another entry in the same archive.
+func (self *TarballURL) relink(path string) *TarballURL {
+	return &TarballURL{
+		Path:          path,
+		ArchiveURL:    self.ArchiveURL,
+		ArchiveFormat: self.ArchiveFormat,
+	}
+}
+
+func (self *TarballURL) openArchive(context contextpkg.Context) (*util.TarballReader, string, error) {
+	if reader, archiveFormat, err := self.openDecompressed(context); err == nil {
+		return util.NewTarballReader(tar.NewReader(reader), reader, nil), archiveFormat, nil
+	} else {
+		return nil, "", err
+	}
+}
+
+// Returns the decompressed archive and the detected archive format.
+func (self *TarballURL) openDecompressed(context contextpkg.Context) (io.ReadCloser, string, error) {
+	if archiveReader, err := self.ArchiveURL.Open(nestedOpen(context)); err == nil {
+		bufferedReader := bufio.NewReaderSize(archiveReader, TARBALL_HEADER_SIZE)
+
+		// Peek returns an error if the archive is shorter than the header,
+		// which is fine, as we are only detecting
+		header, _ := bufferedReader.Peek(TARBALL_HEADER_SIZE)
+
+		archiveFormat := DetectTarballArchiveFormat(header)
+		if archiveFormat == "" {
+			if !IsValidTarballArchiveFormat(self.ArchiveFormat) {
+				archiveReader.Close()
+				return nil, "", &NotImplemented{newURLError(self.Key(), nil, "unsupported tarball archive format %q: %s", self.ArchiveFormat, self.ArchiveURL.String())}
+			}
+			archiveFormat = self.ArchiveFormat
+		} else if archiveFormat != self.ArchiveFormat {
+			log.Debugf("detected tarball archive format %q instead of %q: %s", archiveFormat, self.ArchiveFormat, self.ArchiveURL.String())
+		}
+
+		if decompressor, err := newTarballDecompressor(archiveFormat, bufferedReader); err == nil {
+			if decompressor == nil {
+				return &decompressedReader{bufferedReader, archiveReader, nil}, archiveFormat, nil
+			} else {
+				return &decompressedReader{decompressor, archiveReader, decompressor}, archiveFormat, nil
+			}
+		} else {
+			archiveReader.Close()
+			return nil, "", err
+		}
+	} else {
+		return nil, "", err
+	}
+}
+
+// Detects the archive format from the first [TARBALL_HEADER_SIZE] bytes of a
+// tarball, or fewer. Returns an empty string if the format cannot be detected.
+//
+// Note that old (pre-POSIX) uncompressed tarballs have no magic and cannot be
+// detected.
+func DetectTarballArchiveFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return "tar.gz"
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return "tar.xz"
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "tar.zst"
+	case bytes.HasPrefix(header, []byte{'B', 'Z', 'h'}):
+		return "tar.bz2"
+	case bytes.HasPrefix(header, []byte{0x04, 0x22, 0x4d, 0x18}):
+		return "tar.lz4"
+	case (len(header) >= 262) && (string(header[257:262]) == "ustar"):
+		return "tar"
+	default:
+		return ""
+	}
+}
+
 // Utils
 
+// Returns nil for uncompressed tarballs.
+func newTarballDecompressor(archiveFormat string, reader io.Reader) (io.ReadCloser, error) {
+	switch archiveFormat {
+	case "tar":
+		return nil, nil
+
+	case "tar.gz":
+		return pgzip.NewReader(reader)
+
+	case "tar.xz":
+		if xzReader, err := xz.NewReader(reader); err == nil {
+			return io.NopCloser(xzReader), nil
+		} else {
+			return nil, err
+		}
+
+	case "tar.zst":
+		if zstdDecoder, err := zstd.NewReader(reader); err == nil {
+			return zstdDecoder.IOReadCloser(), nil
+		} else {
+			return nil, err
+		}
+
+	case "tar.bz2":
+		return io.NopCloser(bzip2.NewReader(reader)), nil
+
+	case "tar.lz4":
+		return io.NopCloser(lz4.NewReader(reader)), nil
+
+	default:
+		return nil, NewNotImplementedf("unsupported tarball archive format: %q", archiveFormat)
+	}
+}
+
+// Returns false if the header is not a link.
+func addTarLink(links map[string]*tar.Header, header *tar.Header) bool {
+	if _, _, ok, _ := getTarHeaderLink(header); ok {
+		path := util.FixTarballEntryPath(header.Name)
+		if _, ok := links[path]; !ok {
+			links[path] = header
+		}
+		return true
+	}
+	return false
+}
+
+func getTarLinksFunc(links map[string]*tar.Header) archiveLinkFunc {
+	return func(path string) (string, bool, bool, error) {
+		if header, ok := links[path]; ok {
+			return getTarHeaderLink(header)
+		}
+		return "", false, false, nil
+	}
+}
+
 func parseTarballURL(url string) (string, string, error) {
 	if strings.HasPrefix(url, "tar:") {
-		if split := strings.Split(url[4:], "!"); len(split) == 2 {
-			return split[0], split[1], nil
+		if archiveUrl, path, ok := splitArchiveURL(url[4:]); ok {
+			return archiveUrl, path, nil
 		} else {
-			return "", "", fmt.Errorf("malformed \"tar:\" URL: %s", url)
+			return "", "", &Malformed{newURLError(url, nil, "malformed \"tar:\" URL: %s", url)}
 		}
 	} else {
-		return "", "", fmt.Errorf("not a \"tar:\" URL: %s", url)
+		return "", "", &UnsupportedScheme{newURLError(url, nil, "not a \"tar:\" URL: %s", url)}
+	}
+}
+
+//
+// decompressedReader
+//
+
+type decompressedReader struct {
+	io.Reader
+	archiveReader io.Closer
+	decompressor  io.Closer // can be nil
+}
+
+// ([io.Closer] interface)
+func (self *decompressedReader) Close() error {
+	var err error
+	if self.decompressor != nil {
+		err = self.decompressor.Close()
+	}
+	if err_ := self.archiveReader.Close(); err_ != nil {
+		err = err_
 	}
+	return err
 }
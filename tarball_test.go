@@ -0,0 +1,54 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	contextpkg "context"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestTarballDetection(t *testing.T) {
+	for _, archiveFormat := range exturl.TARBALL_ARCHIVE_FORMATS {
+		t.Run(archiveFormat, func(t *testing.T) {
+			urlContext := exturl.NewContext()
+			defer urlContext.Release()
+
+			content := exturltest.CompressedTarball(t, archiveFormat)
+
+			misleading := "tree.tar.gz"
+			if archiveFormat == "tar.gz" {
+				misleading = "tree.tar.xz"
+			}
+
+			for _, name := range []string{"tree", misleading} {
+				path := writeFile(t, name, content)
+				url := newURL(t, urlContext, "tar:"+urlContext.NewFileURL(path).String()+"!dir/b.json").(*exturl.TarballURL)
+
+				if b, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+					t.Errorf("%s: %s", name, err.Error())
+				} else if b != exturltest.Files["dir/b.json"] {
+					t.Errorf("%s: content: %q", name, b)
+				}
+
+				if detected, err := url.DetectArchiveFormat(contextpkg.TODO()); err != nil {
+					t.Errorf("%s: detect: %s", name, err.Error())
+				} else if detected != archiveFormat {
+					t.Errorf("%s: detected %q", name, detected)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectTarballArchiveFormat(t *testing.T) {
+	if archiveFormat := exturl.DetectTarballArchiveFormat([]byte("not a tarball")); archiveFormat != "" {
+		t.Errorf("detected %q", archiveFormat)
+	}
+
+	if archiveFormat := exturl.DetectTarballArchiveFormat(nil); archiveFormat != "" {
+		t.Errorf("detected %q in empty header", archiveFormat)
+	}
+}
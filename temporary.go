@@ -0,0 +1,236 @@
+package exturl
+
+import (
+	"container/list"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sync"
+)
+
+//
+// temporaryEntry
+//
+
+// A downloaded file or a cloned dir.
+//
+// Entries can be shared between a Context and its children, so they are reference
+// counted. When the last reference is released the file or dir is deleted, unless
+// it is kept for reuse by a temporaryQuota.
+type temporaryEntry struct {
+	key   string
+	path  string
+	dir   bool
+	size  int64
+	refs  int
+	quota *temporaryQuota
+	lock  sync.Mutex
+}
+
+func newTemporaryEntry(key string, path string, dir bool, size int64, quota *temporaryQuota) *temporaryEntry {
+	return &temporaryEntry{
+		key:   key,
+		path:  path,
+		dir:   dir,
+		size:  size,
+		refs:  1,
+		quota: quota,
+	}
+}
+
+// Returns false if the entry has already been released
+func (self *temporaryEntry) retain() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.refs > 0 {
+		self.refs++
+		return true
+	} else {
+		return false
+	}
+}
+
+func (self *temporaryEntry) release() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.refs > 0 {
+		self.refs--
+		if self.refs == 0 {
+			if self.quota != nil {
+				if self.quota.keep(self) {
+					return nil
+				}
+
+				defer self.quota.free(self.size)
+			}
+
+			return self.delete()
+		}
+	}
+
+	return nil
+}
+
+func (self *temporaryEntry) delete() error {
+	if self.dir {
+		return DeleteTemporaryDir(self.path)
+	} else {
+		return DeleteTemporaryFile(self.path)
+	}
+}
+
+//
+// temporaryQuota
+//
+
+// Limits the total size of temporary files and dirs.
+//
+// Released entries are kept for reuse until their space is needed, at which point
+// the least recently released are deleted first.
+type temporaryQuota struct {
+	limit    int64
+	used     int64
+	released *list.List // *temporaryEntry, front is least recently released
+	closed   bool
+	lock     sync.Mutex
+}
+
+func newTemporaryQuota(limit int64) *temporaryQuota {
+	return &temporaryQuota{
+		limit:    limit,
+		released: list.New(),
+	}
+}
+
+func (self *temporaryQuota) reserve(size int64) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for self.used+size > self.limit {
+		if !self.evict() {
+			return NewQuotaExceededf(self.limit, "exturl temporary quota of %d bytes exceeded", self.limit)
+		}
+	}
+
+	self.used += size
+	return nil
+}
+
+func (self *temporaryQuota) free(size int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.used -= size
+}
+
+// Returns false if the quota is closed, in which case the entry should be deleted
+func (self *temporaryQuota) keep(entry *temporaryEntry) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return false
+	}
+
+	self.released.PushBack(entry)
+	return true
+}
+
+// Returns nil if there is no released entry for the key
+func (self *temporaryQuota) revive(key string, dir bool) *temporaryEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for element := self.released.Back(); element != nil; element = element.Prev() {
+		if entry := element.Value.(*temporaryEntry); (entry.key == key) && (entry.dir == dir) {
+			self.released.Remove(element)
+
+			entry.lock.Lock()
+			entry.refs = 1
+			entry.lock.Unlock()
+
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// Deletes all released entries. Entries released afterwards will be deleted
+// immediately.
+func (self *temporaryQuota) close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.closed = true
+
+	var err error
+	for self.released.Len() > 0 {
+		if err_ := self.evictEntry(self.released.Front()); err_ != nil {
+			err = err_
+		}
+	}
+
+	return err
+}
+
+// Call while holding the lock
+func (self *temporaryQuota) evict() bool {
+	if element := self.released.Front(); element != nil {
+		self.evictEntry(element)
+		return true
+	} else {
+		return false
+	}
+}
+
+// Call while holding the lock
+func (self *temporaryQuota) evictEntry(element *list.Element) error {
+	entry := self.released.Remove(element).(*temporaryEntry)
+	self.used -= entry.size
+	return entry.delete()
+}
+
+//
+// quotaWriter
+//
+
+type quotaWriter struct {
+	writer   io.Writer
+	quota    *temporaryQuota
+	reserved int64
+}
+
+// ([io.Writer] interface)
+func (self *quotaWriter) Write(p []byte) (int, error) {
+	size := int64(len(p))
+	if err := self.quota.reserve(size); err != nil {
+		return 0, err
+	}
+	self.reserved += size
+	return self.writer.Write(p)
+}
+
+// Utils
+
+func getDirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !dirEntry.IsDir() {
+			if info, err := dirEntry.Info(); err == nil {
+				size += info.Size()
+			} else {
+				return err
+			}
+		}
+
+		return nil
+	})
+	return size, err
+}
@@ -4,6 +4,7 @@ import (
 	contextpkg "context"
 	"fmt"
 	"io"
+	"io/fs"
 	neturlpkg "net/url"
 	"path/filepath"
 )
@@ -24,8 +25,8 @@ type URL interface {
 	// it might be retrieved from metadata.
 	//
 	// An attempt is made to standardize the return values, e.g. a "yml" file
-	// extension is always returned as "yaml", and a "tar.gz" file extension is
-	// always returned as "tgz".
+	// extension is always returned as "yaml", and a "tgz" file extension is
+	// always returned as "tar.gz".
 	Format() string
 
 	// Returns a URL that is the equivalent of a "base directory" for this URL.
@@ -79,14 +80,82 @@ type URL interface {
 	Context() *Context
 }
 
+//
+// ListableURL
+//
+
+// Implemented by URLs that can list the entries of the directory they refer to.
+type ListableURL interface {
+	URL
+
+	// Returns the direct children. Children that are directories have paths
+	// ending with "/".
+	List(context contextpkg.Context) ([]URL, error)
+}
+
+//
+// RandomAccessURL
+//
+
+// Implemented by URLs that can provide random access to their content, which
+// allows for reading zips without downloading them to temporary files.
+type RandomAccessURL interface {
+	URL
+
+	// Returns the reader and the content size. Returns a [NotImplemented] error
+	// if random access is not available for this URL, e.g. if it depends on
+	// server support.
+	OpenRandomAccess(context contextpkg.Context) (ReaderAtCloser, int64, error)
+}
+
+//
+// StatableURL
+//
+
+// Implemented by URLs that can describe the entry they refer to.
+type StatableURL interface {
+	URL
+
+	// Links in the path are followed, except for the last one, which is
+	// described itself (like [os.Lstat]).
+	Stat(context contextpkg.Context) (fs.FileInfo, error)
+}
+
+type ReaderAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
 // Parses the argument as an absolute URL.
 //
 // To support relative URLs, see [Context.NewValidURL].
 //
 // If you are expecting either a URL or a file path, consider [Context.NewAnyOrFileURL].
 func (self *Context) NewURL(url string) (URL, error) {
+	return self.resolved(self.newUrl(url))
+}
+
+func (self *Context) newUrl(url string) (URL, error) {
 	if mappedUrl, ok := self.GetMapping(url); ok {
-		url = mappedUrl
+		return self.parseUrl(mappedUrl)
+	}
+
+	url_, err := self.parseUrl(url)
+	if err == nil {
+		// Mappings can also be from the URL's key
+		if key := url_.Key(); key != url {
+			if mappedUrl, ok := self.GetMapping(key); ok {
+				return self.parseUrl(mappedUrl)
+			}
+		}
+	}
+
+	return url_, err
+}
+
+func (self *Context) parseUrl(url string) (URL, error) {
+	if mockUrl := self.newMountedMockURL(url); mockUrl != nil {
+		return mockUrl, nil
 	}
 
 	if neturl, err := neturlpkg.ParseRequestURI(url); err == nil {
@@ -115,10 +184,10 @@ func (self *Context) NewURL(url string) (URL, error) {
 			return self.NewInternalURL(url[9:]), nil
 
 		default:
-			return nil, fmt.Errorf("unsupported URL scheme: %q for %s", neturl.Scheme, url)
+			return nil, &UnsupportedScheme{newURLError(url, nil, "unsupported URL scheme: %q for %s", neturl.Scheme, url)}
 		}
 	} else {
-		return nil, fmt.Errorf("malformed URL: %s", url)
+		return nil, &Malformed{newURLError(url, err, "malformed URL: %s", url)}
 	}
 }
 
@@ -157,7 +226,7 @@ func (self *Context) NewAnyOrFileURL(urlOrPath string) URL {
 // If you are expecting either a URL or a file path, consider
 // [Context.NewValidAnyOrFileURL].
 func (self *Context) NewValidURL(context contextpkg.Context, urlOrPath string, bases []URL) (URL, error) {
-	return self.newValidUrl(context, urlOrPath, bases, false)
+	return self.resolved(self.newValidUrl(context, urlOrPath, bases, false))
 }
 
 // Parses the argument as an absolute URL or an absolute file path
@@ -172,19 +241,33 @@ func (self *Context) NewValidURL(context contextpkg.Context, urlOrPath string, b
 // first valid URL will be returned and the remaining bases will be
 // ignored. Note that bases can be any of any URL type.
 func (self *Context) NewValidAnyOrFileURL(context contextpkg.Context, urlOrPath string, bases []URL) (URL, error) {
-	return self.newValidUrl(context, urlOrPath, bases, true)
+	return self.resolved(self.newValidUrl(context, urlOrPath, bases, true))
 }
 
 func (self *Context) newValidUrl(context contextpkg.Context, urlOrPath string, bases []URL, orFile bool) (URL, error) {
 	if mappedUrl, ok := self.GetMapping(urlOrPath); ok {
 		urlOrPath = mappedUrl
+	} else if url, err := self.parseUrl(urlOrPath); err == nil {
+		// Mappings can also be from the URL's key
+		if key := url.Key(); key != urlOrPath {
+			if mappedUrl, ok := self.GetMapping(key); ok {
+				urlOrPath = mappedUrl
+			}
+		}
+	}
+
+	if mockUrl := self.newMountedMockURL(urlOrPath); mockUrl != nil {
+		if !mockUrl.Content.(*MockFS).Exists(mockUrl.Path) {
+			return nil, &NotFound{newURLError(urlOrPath, nil, "mock path not found: %s", urlOrPath)}
+		}
+		return mockUrl, nil
 	}
 
 	if neturl, err := neturlpkg.ParseRequestURI(urlOrPath); err == nil {
 		switch neturl.Scheme {
 		case "http", "https":
 			// Go's "net/http" only handles "http:" and "https:"
-			return self.NewValidNetworkURL(neturl)
+			return self.newValidNetworkURL(context, neturl)
 
 		case "file":
 			filePath := URLPathToFilePath(neturl.Path)
@@ -197,7 +280,7 @@ func (self *Context) newValidUrl(context contextpkg.Context, urlOrPath string, b
 			return self.ParseValidZipURL(context, urlOrPath)
 
 		case "git":
-			return self.ParseValidGitURL(urlOrPath)
+			return self.ParseValidGitURL(context, urlOrPath)
 
 		case "docker":
 			return self.NewValidDockerURL(neturl)
@@ -208,7 +291,7 @@ func (self *Context) newValidUrl(context contextpkg.Context, urlOrPath string, b
 		case "":
 
 		default:
-			return nil, fmt.Errorf("unsupported URL scheme: %q for %s", neturl.Scheme, urlOrPath)
+			return nil, &UnsupportedScheme{newURLError(urlOrPath, nil, "unsupported URL scheme: %q for %s", neturl.Scheme, urlOrPath)}
 		}
 	}
 
@@ -236,5 +319,5 @@ func (self *Context) newValidUrl(context contextpkg.Context, urlOrPath string, b
 		}
 	}
 
-	return nil, fmt.Errorf("invalid URL: %s", urlOrPath)
+	return nil, &NotFound{newURLError(urlOrPath, nil, "invalid URL: %s", urlOrPath)}
 }
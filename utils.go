@@ -1,6 +1,7 @@
 package exturl
 
 import (
+	"bytes"
 	contextpkg "context"
 	"io"
 	neturlpkg "net/url"
@@ -26,6 +27,72 @@ func GetPath(url URL) (string, error) {
 	}
 }
 
+// Opens the URL and returns the reader if it supports random access. Otherwise
+// returns a [NotImplemented] error.
+func openRandomAccess(context contextpkg.Context, url URL) (ReaderAtCloser, int64, error) {
+	if reader, err := url.Open(randomAccessOpen(context)); err == nil {
+		if readerAt, ok := reader.(ReaderAtCloser); ok {
+			if size := getReaderSize(reader); size >= 0 {
+				return readerAt, size, nil
+			}
+		}
+
+		reader.Close()
+		return nil, 0, &NotImplemented{newURLError(url.Key(), nil, "random access not supported: %s", url.Key())}
+	} else {
+		return nil, 0, err
+	}
+}
+
+//
+// bytesReader
+//
+
+// An [io.ReadCloser] for in-memory content that supports random access.
+type bytesReader struct {
+	*bytes.Reader
+}
+
+func newBytesReader(content []byte) *bytesReader {
+	return &bytesReader{bytes.NewReader(content)}
+}
+
+// ([io.Closer] interface)
+func (self *bytesReader) Close() error {
+	return nil
+}
+
+//
+// seekingReader
+//
+
+// Adds Seek to a wrapping reader (for metrics, faults, and lockfiles), so that
+// the content can still be served in ranges (see [Gateway]).
+type seekingReader struct {
+	io.ReadCloser
+	seeker io.Seeker
+}
+
+// Returns the wrapper as is if the wrapped reader does not support Seek.
+func forwardSeek(wrapper io.ReadCloser, wrapped io.ReadCloser) io.ReadCloser {
+	if seeker, ok := wrapped.(io.Seeker); ok {
+		return &seekingReader{wrapper, seeker}
+	}
+	return wrapper
+}
+
+// ([io.Seeker] interface)
+func (self *seekingReader) Seek(offset int64, whence int) (int64, error) {
+	if before, ok := self.ReadCloser.(interface{ beforeSeek() }); ok {
+		before.beforeSeek()
+	}
+	return self.seeker.Seek(offset, whence)
+}
+
+func (self *seekingReader) Size() int64 {
+	return getReaderSize(self.ReadCloser)
+}
+
 func ReadBytes(context contextpkg.Context, url URL) ([]byte, error) {
 	if reader, err := url.Open(context); err == nil {
 		reader = util.NewContextualReadCloser(context, reader)
@@ -54,6 +121,7 @@ func Size(context contextpkg.Context, url URL) (int64, error) {
 	}
 }
 
+// The file is deleted if the download fails.
 func DownloadTo(context contextpkg.Context, url URL, path string) error {
 	if writer, err := os.Create(path); err == nil {
 		if reader, err := url.Open(context); err == nil {
@@ -61,12 +129,16 @@ func DownloadTo(context contextpkg.Context, url URL, path string) error {
 			defer commonlog.CallAndLogWarning(reader.Close, "exturl.DownloadTo", log)
 			log.Infof("downloading from %q to file %q", url.String(), path)
 			if _, err = io.Copy(writer, reader); err == nil {
-				return nil
+				return writer.Close()
 			} else {
 				log.Warningf("failed to download from %q", url.String())
+				writer.Close()
+				os.Remove(path)
 				return err
 			}
 		} else {
+			writer.Close()
+			os.Remove(path)
 			return err
 		}
 	} else {
@@ -74,28 +146,94 @@ func DownloadTo(context contextpkg.Context, url URL, path string) error {
 	}
 }
 
+// Downloads to a temporary file in the default temporary directory.
 func Download(context contextpkg.Context, url URL, temporaryPathPattern string) (*os.File, error) {
-	if file, err := os.CreateTemp("", temporaryPathPattern); err == nil {
-		path := file.Name()
+	file, _, err := download(context, url.Context(), url, "", temporaryPathPattern, nil)
+	return file, err
+}
+
+// Events, metrics, and spans are reported to "urlContext". "dir" can be an empty
+// string to use the default temporary directory. "quota" can be nil.
+func download(context contextpkg.Context, urlContext *Context, url URL, dir string, temporaryPathPattern string, quota *temporaryQuota) (*os.File, int64, error) {
+	var file *os.File
+	var size int64
+	context, countError := countErrorOnce(context)
+	err := urlContext.trace(context, "exturl.Download", url, func(context contextpkg.Context) error {
+		var err error
+		file, size, err = downloadToTemporaryFile(context, urlContext, url, dir, temporaryPathPattern, quota)
+		return err
+	})
+
+	if (err != nil) && countError {
+		urlContext.countError(url, err)
+	}
+
+	return file, size, err
+}
+
+func downloadToTemporaryFile(context contextpkg.Context, urlContext *Context, url URL, dir string, temporaryPathPattern string, quota *temporaryQuota) (*os.File, int64, error) {
+	return writeTemporaryFile(dir, temporaryPathPattern, quota, func(path string, writer io.Writer) (int64, error) {
 		if reader, err := url.Open(context); err == nil {
+			total := getReaderSize(reader)
 			reader = util.NewContextualReadCloser(context, reader)
 			defer commonlog.CallAndLogWarning(reader.Close, "exturl.Download", log)
+
 			log.Infof("downloading from %q to temporary file %q", url.String(), path)
-			if _, err = io.Copy(file, reader); err == nil {
-				util.OnExitError(func() error {
-					return DeleteTemporaryFile(path)
-				})
-				return file, nil
+			urlContext.emit(&Event{Type: EventDownloadStarted, URL: url, Total: total})
+			if urlContext.hasObservers() {
+				writer = urlContext.newProgressWriter(writer, EventDownloadProgress, url, total)
+			}
+
+			if size, err := io.Copy(writer, reader); err == nil {
+				urlContext.emit(&Event{Type: EventDownloadCompleted, URL: url, Bytes: size, Total: total})
+				return size, nil
 			} else {
 				log.Warningf("failed to download from %q", url.String())
-				DeleteTemporaryFile(path)
-				return nil, err
+				urlContext.emit(&Event{Type: EventDownloadCompleted, URL: url, Total: total, Error: err})
+				return 0, err
 			}
 		} else {
+			return 0, err
+		}
+	})
+}
+
+// Creates a temporary file with a pid file (see [CleanStaleTemporaryFiles]) and
+// calls "write" to fill it, counting the bytes against the quota if not nil. The
+// file is deleted if "write" fails, and otherwise on exit if not deleted before.
+//
+// The returned file is open.
+func writeTemporaryFile(dir string, temporaryPathPattern string, quota *temporaryQuota, write func(path string, writer io.Writer) (int64, error)) (*os.File, int64, error) {
+	if file, err := os.CreateTemp(dir, temporaryPathPattern); err == nil {
+		path := file.Name()
+
+		if err := writePidFile(path); err != nil {
+			file.Close()
+			DeleteTemporaryFile(path)
+			return nil, 0, err
+		}
+
+		var writer io.Writer = file
+		var quotaWriter_ *quotaWriter
+		if quota != nil {
+			quotaWriter_ = &quotaWriter{writer: file, quota: quota}
+			writer = quotaWriter_
+		}
+
+		if size, err := write(path, writer); err == nil {
+			util.OnExitError(func() error {
+				return DeleteTemporaryFile(path)
+			})
+			return file, size, nil
+		} else {
+			file.Close()
 			DeleteTemporaryFile(path)
-			return nil, err
+			if quotaWriter_ != nil {
+				quota.free(quotaWriter_.reserved)
+			}
+			return nil, 0, err
 		}
 	} else {
-		return nil, err
+		return nil, 0, err
 	}
 }
@@ -0,0 +1,107 @@
+package exturl
+
+import (
+	contextpkg "context"
+
+	"github.com/klauspost/compress/zip"
+)
+
+//
+// ZipIndex
+//
+
+// A parsed zip central directory with lookup of entries by name and of the
+// direct children of dirs.
+//
+// Indexes are cached per [Context] (see [ZipURL.OpenArchive]) and are safe for
+// concurrent use.
+type ZipIndex struct {
+	ZipReader *zip.Reader
+
+	// Entry name to file
+	Files map[string]*zip.File
+
+	// Dir path (without trailing "/", and an empty string for the root) to the
+	// paths of its direct children (with a trailing "/" for dirs)
+	Dirs map[string][]string
+
+	reader ReaderAtCloser
+}
+
+func NewZipIndex(zipReader *zip.Reader, reader ReaderAtCloser) *ZipIndex {
+	files := make(map[string]*zip.File, len(zipReader.File))
+	names := make([]string, len(zipReader.File))
+	for index, file := range zipReader.File {
+		// The first entry with a name wins, as in a sequential search and in
+		// [TarIndex]
+		if _, ok := files[file.Name]; !ok {
+			files[file.Name] = file
+		}
+		names[index] = file.Name
+	}
+
+	return &ZipIndex{
+		ZipReader: zipReader,
+		Files:     files,
+		Dirs:      getArchiveDirs(names),
+		reader:    reader,
+	}
+}
+
+// ([io.Closer] interface)
+func (self *ZipIndex) Close() error {
+	return self.reader.Close()
+}
+
+// Shared by all callers for the same archive key until [Context.Release].
+func (self *Context) getZipIndex(context contextpkg.Context, zipUrl *ZipURL) (*ZipIndex, error) {
+	key := zipUrl.ArchiveURL.Key()
+
+	self.lock.Lock()
+	zipIndex, ok := self.zipIndexes[key]
+	releases := self.releases
+	self.lock.Unlock()
+
+	if ok {
+		self.cacheLookup(zipUrl.ArchiveURL, true, CacheKindZipIndex)
+		return zipIndex, nil
+	}
+
+	self.cacheLookup(zipUrl.ArchiveURL, false, CacheKindZipIndex)
+
+	if zipIndex, err := self.zipIndexFlights.Do(context, key, func(context contextpkg.Context) (any, error) {
+		self.lock.Lock()
+		zipIndex, ok := self.zipIndexes[key]
+		self.lock.Unlock()
+
+		if ok {
+			return zipIndex, nil
+		}
+
+		// The reader outlives this call, so it must not be cancelled with it
+		if zipReader, err := zipUrl.openArchive(contextpkg.WithoutCancel(context)); err == nil {
+			zipIndex := NewZipIndex(zipReader.ZipReader, zipReader.Reader)
+
+			self.lock.Lock()
+			if self.releases != releases {
+				// Release will not close it
+				self.lock.Unlock()
+				zipIndex.Close()
+				return nil, newReleasedError(key)
+			}
+			if self.zipIndexes == nil {
+				self.zipIndexes = make(map[string]*ZipIndex)
+			}
+			self.zipIndexes[key] = zipIndex
+			self.lock.Unlock()
+
+			return zipIndex, nil
+		} else {
+			return nil, err
+		}
+	}); err == nil {
+		return zipIndex.(*ZipIndex), nil
+	} else {
+		return nil, err
+	}
+}
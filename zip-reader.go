@@ -2,62 +2,140 @@ package exturl
 
 import (
 	"io"
+	"io/fs"
 	"os"
+	"strings"
 
 	"github.com/klauspost/compress/zip"
 )
 
+// Longer symlink targets are rejected as [Malformed].
+const ZIP_MAX_SYMLINK_SIZE = 4096
+
 //
 // ZipReader
 //
 
 type ZipReader struct {
 	ZipReader *zip.Reader
-	File      *os.File
+	Reader    ReaderAtCloser // nil if owned by Index
+	Index     *ZipIndex      // can be nil
+}
+
+func NewZipReader(zipReader *zip.Reader, reader ReaderAtCloser) *ZipReader {
+	return &ZipReader{ZipReader: zipReader, Reader: reader}
 }
 
-func NewZipReader(reader *zip.Reader, file *os.File) *ZipReader {
-	return &ZipReader{reader, file}
+// The index keeps ownership of its reader, so closing the returned ZipReader
+// does nothing.
+func NewZipReaderForIndex(zipIndex *ZipIndex) *ZipReader {
+	return &ZipReader{ZipReader: zipIndex.ZipReader, Index: zipIndex}
 }
 
+// Takes ownership of "reader" and will close it on error.
+func NewZipReaderFor(reader ReaderAtCloser, size int64) (*ZipReader, error) {
+	if zipReader, err := zip.NewReader(reader, size); err == nil {
+		return NewZipReader(zipReader, reader), nil
+	} else {
+		reader.Close()
+		return nil, err
+	}
+}
+
+// Takes ownership of "file" and will close it on error.
 func NewZipReaderForFile(file *os.File) (*ZipReader, error) {
 	if stat, err := file.Stat(); err == nil {
-		size := stat.Size()
-		if zipReader, err := zip.NewReader(file, size); err == nil {
-			return NewZipReader(zipReader, file), nil
-		} else {
-			return nil, err
-		}
+		return NewZipReaderFor(file, stat.Size())
 	} else {
+		file.Close()
 		return nil, err
 	}
 }
 
 // ([io.Closer] interface)
 func (self *ZipReader) Close() error {
-	return self.File.Close()
+	if self.Reader != nil {
+		return self.Reader.Close()
+	}
+	return nil
 }
 
-func (self *ZipReader) Open(path string) (*ZipEntryReader, error) {
+// Returns nil if not found.
+func (self *ZipReader) Get(path string) *zip.File {
+	if self.Index != nil {
+		return self.Index.Files[path]
+	}
+
 	for _, file := range self.ZipReader.File {
 		if path == file.Name {
-			if entryReader, err := file.Open(); err == nil {
-				return NewZipEntryReader(entryReader, self), nil
-			} else {
-				return nil, err
-			}
+			return file
+		}
+	}
+	return nil
+}
+
+func (self *ZipReader) Open(path string) (*ZipEntryReader, error) {
+	if file := self.Get(path); file != nil {
+		if entryReader, err := file.Open(); err == nil {
+			return NewZipEntryReader(entryReader, self), nil
+		} else {
+			return nil, err
 		}
 	}
 	return nil, nil
 }
 
 func (self *ZipReader) Has(path string) bool {
-	for _, file := range self.ZipReader.File {
-		if path == file.Name {
-			return true
+	return self.Get(path) != nil
+}
+
+// ([archiveLinkFunc] signature)
+//
+// Zip symlinks are entries with the symlink mode, and their content is the
+// target. Zips have no hardlinks.
+func (self *ZipReader) getLink(path string) (string, bool, bool, error) {
+	if file := self.Get(path); (file != nil) && (file.Mode()&fs.ModeSymlink != 0) {
+		if reader, err := file.Open(); err == nil {
+			defer reader.Close()
+			if target, err := io.ReadAll(io.LimitReader(reader, ZIP_MAX_SYMLINK_SIZE+1)); err == nil {
+				if len(target) > ZIP_MAX_SYMLINK_SIZE {
+					return "", false, false, NewMalformedf("zip symlink target is too long: %s", path)
+				}
+				return string(target), false, true, nil
+			} else {
+				return "", false, false, err
+			}
+		} else {
+			return "", false, false, err
 		}
 	}
-	return false
+	return "", false, false, nil
+}
+
+// Returns nil if not found.
+func (self *ZipReader) stat(path string) fs.FileInfo {
+	if file := self.Get(path); file != nil {
+		return file.FileInfo()
+	} else if file := self.Get(strings.TrimSuffix(path, "/") + "/"); file != nil {
+		return file.FileInfo()
+	}
+
+	var dirs map[string][]string
+	if self.Index != nil {
+		dirs = self.Index.Dirs
+	} else {
+		names := make([]string, len(self.ZipReader.File))
+		for index, file := range self.ZipReader.File {
+			names[index] = file.Name
+		}
+		dirs = getArchiveDirs(names)
+	}
+
+	if _, ok := dirs[strings.Trim(path, "/")]; ok {
+		return archiveDirInfo{path}
+	}
+
+	return nil
 }
 
 func (self *ZipReader) Iterate(f func(*zip.File) bool) {
@@ -4,6 +4,7 @@ import (
 	contextpkg "context"
 	"fmt"
 	"io"
+	"io/fs"
 	pathpkg "path"
 	"strings"
 )
@@ -33,16 +34,22 @@ func NewZipURL(path string, archiveUrl URL) *ZipURL {
 
 func NewValidZipURL(context contextpkg.Context, path string, archiveUrl URL) (*ZipURL, error) {
 	self := NewZipURL(path, archiveUrl)
+	return self.validate(context)
+}
+
+func (self *ZipURL) validate(context contextpkg.Context) (*ZipURL, error) {
 	if zipReader, err := self.OpenArchive(context); err == nil {
 		defer zipReader.Close()
 
-		for _, file := range zipReader.ZipReader.File {
-			if self.Path == file.Name {
+		if path, err := resolveArchivePath(self.Key(), self.Path, true, zipReader.getLink); err == nil {
+			if zipReader.Has(path) {
 				return self, nil
 			}
+		} else {
+			return nil, err
 		}
 
-		return nil, NewNotFoundf("path %q not found in zip: %s", path, archiveUrl.String())
+		return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in zip: %s", self.Path, self.ArchiveURL.String())}
 	} else {
 		return nil, err
 	}
@@ -78,10 +85,7 @@ func (self *ZipURL) Format() string {
 
 // ([URL] interface)
 func (self *ZipURL) Base() URL {
-	path := pathpkg.Dir(self.Path)
-	if path != "/" {
-		path += "/"
-	}
+	path := getArchiveBasePath(self.Path)
 
 	return &ZipURL{
 		Path:       path,
@@ -99,17 +103,8 @@ func (self *ZipURL) Relative(path string) URL {
 
 // ([URL] interface)
 func (self *ZipURL) ValidRelative(context contextpkg.Context, path string) (URL, error) {
-	zipUrl := self.Relative(path).(*ZipURL)
-	if zipReader, err := zipUrl.OpenArchive(context); err == nil {
-		defer zipReader.Close()
-
-		for _, file := range zipReader.ZipReader.File {
-			if zipUrl.Path == file.Name {
-				return zipUrl, nil
-			}
-		}
-
-		return nil, NewNotFoundf("path %q not found in zip: %s", zipUrl.Path, zipUrl.ArchiveURL.String())
+	if zipUrl, err := self.Relative(path).(*ZipURL).validate(context); err == nil {
+		return zipUrl, nil
 	} else {
 		return nil, err
 	}
@@ -117,18 +112,27 @@ func (self *ZipURL) ValidRelative(context contextpkg.Context, path string) (URL,
 
 // ([URL] interface)
 func (self *ZipURL) Key() string {
-	return fmt.Sprintf("zip:%s!/%s", self.ArchiveURL.String(), self.Path)
+	return fmt.Sprintf("zip:%s!/%s", self.ArchiveURL.String(), escapeArchivePath(self.Path))
 }
 
 // ([URL] interface)
 func (self *ZipURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
+	return self.Context().open(context, self, self.open)
+}
+
+func (self *ZipURL) open(context contextpkg.Context) (io.ReadCloser, error) {
 	if zipReader, err := self.OpenArchive(context); err == nil {
-		if zipEntryReader, err := zipReader.Open(self.Path); err == nil {
-			if zipEntryReader != nil {
-				return zipEntryReader, nil
+		if path, err := resolveArchivePath(self.Key(), self.Path, true, zipReader.getLink); err == nil {
+			if zipEntryReader, err := zipReader.Open(path); err == nil {
+				if zipEntryReader != nil {
+					return zipEntryReader, nil
+				} else {
+					zipReader.Close()
+					return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+				}
 			} else {
 				zipReader.Close()
-				return nil, NewNotFoundf("path %q not found in archive: %s", self.Path, self.ArchiveURL.String())
+				return nil, err
 			}
 		} else {
 			zipReader.Close()
@@ -139,12 +143,83 @@ func (self *ZipURL) Open(context contextpkg.Context) (io.ReadCloser, error) {
 	}
 }
 
+// ([ListableURL] interface)
+func (self *ZipURL) List(context contextpkg.Context) ([]URL, error) {
+	if zipReader, err := self.OpenArchive(context); err == nil {
+		defer zipReader.Close()
+
+		if path, err := resolveArchivePath(self.Key(), self.Path, true, zipReader.getLink); err == nil {
+			if paths, ok := zipReader.Index.Dirs[strings.Trim(path, "/")]; ok {
+				paths = relinkArchivePaths(paths, self.Path, path)
+				urls := make([]URL, len(paths))
+				for index, path := range paths {
+					urls[index] = &ZipURL{
+						Path:       path,
+						ArchiveURL: self.ArchiveURL,
+					}
+				}
+				return urls, nil
+			} else {
+				return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+			}
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// ([StatableURL] interface)
+func (self *ZipURL) Stat(context contextpkg.Context) (fs.FileInfo, error) {
+	if zipReader, err := self.OpenArchive(context); err == nil {
+		defer zipReader.Close()
+
+		if path, err := resolveArchivePath(self.Key(), self.Path, false, zipReader.getLink); err == nil {
+			if info := zipReader.stat(path); info != nil {
+				return info, nil
+			} else {
+				return nil, &NotFound{newURLError(self.Key(), nil, "path %q not found in archive: %s", self.Path, self.ArchiveURL.String())}
+			}
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
 // ([URL] interface)
 func (self *ZipURL) Context() *Context {
 	return self.ArchiveURL.Context()
 }
 
+// The archive's [ZipIndex] is cached in the context, so that the archive is only
+// opened and its central directory only parsed once per context.
+//
+// Archives are read directly if the archive URL is a [RandomAccessURL], e.g.
+// for local files, in-memory content, or remote archives on servers that support
+// range requests (see [RangeReaderAt]). Otherwise the entire archive is
+// downloaded.
 func (self *ZipURL) OpenArchive(context contextpkg.Context) (*ZipReader, error) {
+	if zipIndex, err := self.Context().getZipIndex(context, self); err == nil {
+		return NewZipReaderForIndex(zipIndex), nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *ZipURL) openArchive(context contextpkg.Context) (*ZipReader, error) {
+	context = nestedOpen(context)
+
+	if randomAccessUrl, ok := self.ArchiveURL.(RandomAccessURL); ok {
+		if reader, size, err := randomAccessUrl.OpenRandomAccess(context); err == nil {
+			return NewZipReaderFor(reader, size)
+		} else if !IsNotImplemented(err) {
+			return nil, err
+		}
+	}
+
 	if file, err := self.ArchiveURL.Context().OpenFile(context, self.ArchiveURL); err == nil {
 		return NewZipReaderForFile(file)
 	} else {
@@ -156,12 +231,12 @@ func (self *ZipURL) OpenArchive(context contextpkg.Context) (*ZipReader, error)
 
 func parseZipURL(url string) (string, string, error) {
 	if strings.HasPrefix(url, "zip:") {
-		if split := strings.Split(url[4:], "!"); len(split) == 2 {
-			return split[0], split[1], nil
+		if archiveUrl, path, ok := splitArchiveURL(url[4:]); ok {
+			return archiveUrl, path, nil
 		} else {
-			return "", "", fmt.Errorf("malformed \"zip:\" URL: %s", url)
+			return "", "", &Malformed{newURLError(url, nil, "malformed \"zip:\" URL: %s", url)}
 		}
 	} else {
-		return "", "", fmt.Errorf("not a \"zip:\" URL: %s", url)
+		return "", "", &UnsupportedScheme{newURLError(url, nil, "not a \"zip:\" URL: %s", url)}
 	}
 }
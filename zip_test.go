@@ -0,0 +1,115 @@
+//go:build !wasip1
+
+package exturl_test
+
+import (
+	"archive/zip"
+	"bytes"
+	contextpkg "context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/tliron/exturl"
+	"github.com/tliron/exturl/exturltest"
+)
+
+func TestZipWithoutTemporaryFiles(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	dir := t.TempDir()
+	urlContext.SetTemporaryDir(dir)
+
+	content := exturltest.Zip(t)
+
+	exturl.RegisterInternalURL("/exturltest/tree.zip", content)
+	defer exturl.DeregisterInternalURL("/exturltest/tree.zip")
+
+	fs := exturl.NewMockFS()
+	fs.Add("tree.zip", content)
+	urlContext.MountMockFS("https://mock.example", fs)
+
+	for _, url := range []string{
+		"zip:internal:/exturltest/tree.zip!dir/b.json",
+		"zip:https://mock.example/tree.zip!dir/b.json",
+		"zip:" + urlContext.NewFileURL(writeFile(t, "tree.zip", content)).String() + "!dir/b.json",
+	} {
+		if b, err := exturl.ReadString(contextpkg.TODO(), newURL(t, urlContext, url)); err != nil {
+			t.Errorf("%s: %s", url, err.Error())
+		} else if b != exturltest.Files["dir/b.json"] {
+			t.Errorf("%s: content: %q", url, b)
+		}
+	}
+
+	if dirEntries, err := os.ReadDir(dir); err != nil {
+		t.Errorf("temporary dir: %s", err.Error())
+	} else if len(dirEntries) != 0 {
+		t.Errorf("temporary files: %d", len(dirEntries))
+	}
+}
+
+func TestZipIndex(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	path := writeFile(t, "tree.zip", exturltest.Zip(t))
+	archiveUrl := urlContext.NewFileURL(path)
+
+	var lock sync.Mutex
+	var archiveOpens int
+	urlContext.AddObserver(exturl.ObserverFunc(func(event *exturl.Event) {
+		if (event.Type == exturl.EventOpen) && (event.URL.Key() == archiveUrl.Key()) {
+			lock.Lock()
+			archiveOpens++
+			lock.Unlock()
+		}
+	}))
+
+	base := exturl.NewZipURL("", archiveUrl)
+
+	var wait sync.WaitGroup
+	for range 50 {
+		for _, path := range []string{"a.yaml", "dir/b.json", "dir/sub/c.txt"} {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				if url, err := base.ValidRelative(contextpkg.TODO(), path); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				} else if b, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+					t.Errorf("%s: %s", path, err.Error())
+				} else if b != exturltest.Files[path] {
+					t.Errorf("%s: content: %q", path, b)
+				}
+			}()
+		}
+	}
+	wait.Wait()
+
+	if archiveOpens != 1 {
+		t.Errorf("archive opened %d times", archiveOpens)
+	}
+}
+
+func TestZipDuplicateEntries(t *testing.T) {
+	urlContext := exturl.NewContext()
+	defer urlContext.Release()
+
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	for _, content := range []string{"first", "second"} {
+		if writer, err := zipWriter.Create("a.yaml"); err == nil {
+			writer.Write([]byte(content))
+		} else {
+			t.Fatal(err)
+		}
+	}
+	zipWriter.Close()
+
+	url := newURL(t, urlContext, "zip:"+urlContext.NewFileURL(writeFile(t, "duplicate.zip", buffer.Bytes())).String()+"!a.yaml")
+	if b, err := exturl.ReadString(contextpkg.TODO(), url); err != nil {
+		t.Errorf("read: %s", err.Error())
+	} else if b != "first" {
+		t.Errorf("content: %q", b)
+	}
+}